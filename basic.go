@@ -2,168 +2,76 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/google/btree"
 )
 
-type Token int
+type Basic struct {
+	Vars   []Value
+	Names  map[string]int
+	Code   *btree.BTree
+	W      io.Writer
+	ErrW   io.Writer
+	FS     FS
+	Screen Printer
+	Files  [MaxFiles + 1]*File
+	Fields map[int]Field
+
+	// MBF emulates the Microsoft Binary Format arithmetic of BASIC-80: single precision
+	// results are rounded with halves away from zero, and results outside of the range of
+	// MBF overflow or become zero.
+	MBF bool
+
+	// DefTypes are the types of variables without a type suffix, by first letter; they are
+	// single precision unless changed by DEFINT, DEFSNG, DEFDBL, or DEFSTR.
+	DefTypes [26]Type
+
+	// Crunched loads programs which were written without spaces around keywords; see
+	// TokenReader.
+	Crunched bool
+
+	// Profile, if not nil, records how many times each line runs and the time spent in it.
+	Profile *Profile
+
+	// In is the keyboard, which INPUT and LINE INPUT without a file number read lines from;
+	// if it is nil, they fail with Input past end. If Echo is set, the lines are also
+	// written to the screen, as a terminal would show them.
+	In   *bufio.Reader
+	Echo bool
+
+	// Source is the line of the file that each line of the program was loaded from, by line
+	// number; lines entered at the prompt are not in it.
+	Source map[int]int
+
+	status      int   // the exit status of the program which ran last
+	system      bool  // SYSTEM was run, so BASIC should exit
+	interrupted int32 // set by Interrupt
+	stepLimit   int   // if positive, a run is interrupted after this many instructions
+}
 
+// The exit statuses of a program, other than one given by END or SYSTEM.
 const (
-	EndOfLine Token = iota
-	KeywordToken
-	IntegerToken
-	StringToken
-	OperatorToken
+	ExitLoadError    = 3   // the program could not be loaded or compiled
+	ExitRuntimeError = 4   // the program stopped with an error
+	ExitBreak        = 130 // the program was interrupted, as a shell reports SIGINT
 )
 
-type TokenReader struct {
-	R      *bufio.Reader
-	AtEOL  bool
-	peeked bool
-	t      Token
-	n      int
-	s      string
-}
-
-func (tr *TokenReader) ReadRuneEOF() (rune, bool) {
-	ch, _, err := tr.R.ReadRune()
-	if err == io.EOF {
-		return 0, true
-	}
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "basic: fatal: %s\n", err)
-		os.Exit(1)
-	}
-	return ch, false
-}
-
-func (tr *TokenReader) ReadRune() rune {
-	ch, eof := tr.ReadRuneEOF()
-	if eof {
-		fmt.Fprintf(os.Stderr, "basic: fatal: unexpected EOF")
-		os.Exit(1)
-	}
-	return ch
-}
-
-func (tr *TokenReader) UnreadRune() {
-	tr.R.UnreadRune()
-}
-
-func (tr *TokenReader) ReadToken() (Token, int, string) {
-	if tr.peeked {
-		tr.peeked = false
-		return tr.t, tr.n, tr.s
-	}
-
-	tr.AtEOL = false
-	for {
-		var ch rune
-		for {
-			ch = tr.ReadRune()
-			if ch != ' ' {
-				break
-			}
-		}
-
-		if ch == '\n' {
-			tr.AtEOL = true
-			return EndOfLine, 0, ""
-		} else if ch == '\'' {
-			for ch != '\n' {
-				ch = tr.ReadRune()
-			}
-			tr.AtEOL = true
-			return EndOfLine, 0, ""
-		}
-
-		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') {
-			kw := string(ch)
-			for {
-				ch = tr.ReadRune()
-				if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') {
-					kw += string(ch)
-				} else if ch == '$' || ch == '%' {
-					kw += string(ch)
-					break
-				} else {
-					tr.UnreadRune()
-					break
-				}
-			}
-			return KeywordToken, 0, strings.ToUpper(kw)
-		}
-
-		if ch >= '0' && ch <= '9' {
-			var n int
-			for ch >= '0' && ch <= '9' {
-				n = n*10 + (int(ch) - '0')
-				ch = tr.ReadRune()
-			}
-			tr.UnreadRune()
-			return IntegerToken, n, ""
-		}
-
-		if ch == '-' || ch == '+' || ch == '*' || ch == '/' || ch == '(' || ch == ')' ||
-			ch == ',' || ch == '=' {
-			return OperatorToken, 0, string(ch)
-		} else if ch == '<' {
-			ch = tr.ReadRune()
-			if ch == '=' {
-				return OperatorToken, 0, "<="
-			} else if ch == '>' {
-				return OperatorToken, 0, "<>"
-			}
-			tr.UnreadRune()
-			return OperatorToken, 0, "<"
-		} else if ch == '>' {
-			if ch == '=' {
-				return OperatorToken, 0, ">="
-			}
-			tr.UnreadRune()
-			return OperatorToken, 0, ">"
-		}
-
-		if ch == '"' {
-			var s string
-			for {
-				ch = tr.ReadRune()
-				if ch == '"' {
-					break
-				}
-				s += string(ch)
-			}
-			return StringToken, 0, s
-		}
-	}
-}
-
-func (tr *TokenReader) PeekToken() (Token, int, string) {
-	if tr.peeked {
-		return tr.t, tr.n, tr.s
-	}
-
-	tr.t, tr.n, tr.s = tr.ReadToken()
-	tr.peeked = true
-	return tr.t, tr.n, tr.s
-}
-
-type Basic struct {
-	Vars map[string]interface{}
-	Code *btree.BTree
-	W    io.Writer
-	ErrW io.Writer
-}
-
 func NewBasic(w, errW io.Writer) *Basic {
 	b := &Basic{
-		W:    w,
-		ErrW: errW,
+		W:      w,
+		ErrW:   errW,
+		FS:     OSFS{},
+		Screen: Printer{W: w, Width: DefaultWidth},
 	}
 	b.New()
 	return b
@@ -177,12 +85,35 @@ func (b *Basic) Error(tr *TokenReader, msg string) {
 }
 
 func (b *Basic) New() {
-	b.Vars = map[string]interface{}{}
+	b.CloseFiles()
+	b.ResetDefTypes()
+	b.Vars = nil
+	b.Names = map[string]int{}
 	b.Code = btree.New(4)
+	b.Source = map[int]int{}
+}
+
+// ResetDefTypes makes variables without a type suffix single precision; it is done when
+// a program is compiled, before any DEFINT, DEFSNG, DEFDBL, or DEFSTR statements.
+func (b *Basic) ResetDefTypes() {
+	for i := range b.DefTypes {
+		b.DefTypes[i] = SingleType
+	}
+}
+
+// Slot returns the index in Vars of the variable called name, allocating one if necessary.
+func (b *Basic) Slot(name string) int {
+	if slot, ok := b.Names[name]; ok {
+		return slot
+	}
+	slot := len(b.Vars)
+	b.Vars = append(b.Vars, Value{})
+	b.Names[name] = slot
+	return slot
 }
 
 func (b *Basic) Save(fn string) {
-	f, err := os.Create(fn)
+	f, err := Create(b.FS, fn)
 	if err != nil {
 		fmt.Fprintf(b.ErrW, "basic: error: SAVE: %s\n", err)
 		return
@@ -202,7 +133,17 @@ func (b *Basic) Save(fn string) {
 }
 
 func (b *Basic) Load(fn string) bool {
-	f, err := os.Open(fn)
+	return b.load(fn, false)
+}
+
+// Merge adds the lines of the file fn to the program, replacing any lines with the same
+// numbers.
+func (b *Basic) Merge(fn string) bool {
+	return b.load(fn, true)
+}
+
+func (b *Basic) load(fn string, merge bool) bool {
+	f, err := b.FS.Open(fn)
 	if err != nil {
 		fmt.Fprintf(b.ErrW, "basic: error: OPEN: %s\n", err)
 		return false
@@ -210,30 +151,45 @@ func (b *Basic) Load(fn string) bool {
 	defer f.Close()
 
 	vars := b.Vars
+	names := b.Names
 	code := b.Code
-	b.New()
+	source := b.Source
+	if merge {
+		b.Names = map[string]int{}
+		for name, slot := range names {
+			b.Names[name] = slot
+		}
+		b.Code = code.Clone()
+		b.Source = map[int]int{}
+		for n, l := range source {
+			b.Source[n] = l
+		}
+	} else {
+		b.New()
+	}
 
 	tr := &TokenReader{
-		R: bufio.NewReader(f),
+		R:        bufio.NewReader(f),
+		Crunched: b.Crunched,
 	}
 
 	for {
-		for {
-			ch, eof := tr.ReadRuneEOF()
-			if eof {
+		if !tr.SkipBlankLines() {
+			if tr.Err != nil {
+				fmt.Fprintf(b.ErrW, "basic: error: LOAD: %s\n", tr.Err)
+			} else if b.Check() {
 				return true
 			}
-			if ch != ' ' && ch != '\n' {
-				break
-			}
+			break
 		}
-		tr.UnreadRune()
 
 		t, n, _ := tr.ReadToken()
 		if t == IntegerToken {
+			pos := tr.Pos
 			stmt, ok := b.CompileStatement(tr, true)
 			if ok {
 				b.Code.ReplaceOrInsert(Line{n, stmt})
+				b.Source[n] = pos.Line
 			} else {
 				break
 			}
@@ -244,75 +200,97 @@ func (b *Basic) Load(fn string) bool {
 	}
 
 	b.Vars = vars
+	b.Names = names
 	b.Code = code
+	b.Source = source
 	return false
 }
 
 type Expr interface {
 	fmt.Stringer
 	Print(w io.Writer)
-	Eval(b *Basic) (interface{}, bool)
+	Compile(c *Compiler) (Type, bool)
 }
 
+// ValueExpr is a constant; Text is the number as it was written, if it was written in the
+// program, so that LIST shows &HFF as &HFF and 1.5# as 1.5#.
 type ValueExpr struct {
-	Value interface{}
+	Value Value
+	Text  string
 }
 
+// String returns the constant as it would be written in a program. A string containing
+// quotes can't be written as a single string constant, so it is written as strings joined
+// by CHR$(34).
 func (ve ValueExpr) String() string {
-	switch v := ve.Value.(type) {
-	case int:
-		return fmt.Sprintf("%d", v)
-	case string:
-		return v
-	case bool:
-		if v {
-			return "TRUE"
-		} else {
-			return "FALSE"
+	if ve.Text != "" {
+		return ve.Text
+	} else if ve.Value.Type != StringType {
+		return ve.Value.Format()
+	} else if !strings.Contains(ve.Value.String, `"`) {
+		return `"` + ve.Value.String + `"`
+	}
+
+	var parts []string
+	for i, s := range strings.Split(ve.Value.String, `"`) {
+		if i > 0 {
+			parts = append(parts, "CHR$(34)")
+		}
+		if s != "" {
+			parts = append(parts, `"`+s+`"`)
 		}
-	default:
-		panic("unexpected value type")
 	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return "(" + strings.Join(parts, " + ") + ")"
 }
 
 func (ve ValueExpr) Print(w io.Writer) {
-	switch v := ve.Value.(type) {
-	case int:
-		fmt.Fprintf(w, "%d", v)
-	case string:
-		fmt.Fprintf(w, `"%s"`, v)
-	case bool:
-		if v {
-			fmt.Fprint(w, "TRUE")
-		} else {
-			fmt.Fprint(w, "FALSE")
-		}
-	default:
-		panic("unexpected value type")
-	}
+	fmt.Fprint(w, ve.String())
 }
 
-func (ve ValueExpr) Eval(b *Basic) (interface{}, bool) {
-	return ve.Value, true
+func (ve ValueExpr) Compile(c *Compiler) (Type, bool) {
+	c.EmitConst(ve.Value)
+	return ve.Value.Type, true
 }
 
-type VarExpr string
+// VarExpr is a variable; its slot and type are found when it is compiled, since the type of
+// a name without a suffix depends on DEFINT and the like.
+type VarExpr struct {
+	Name string
+}
 
 func (ve VarExpr) String() string {
-	return string(ve)
+	return ve.Name
 }
 
 func (ve VarExpr) Print(w io.Writer) {
-	fmt.Fprint(w, string(ve))
+	fmt.Fprint(w, ve.Name)
 }
 
-func (ve VarExpr) Eval(b *Basic) (interface{}, bool) {
-	val, ok := b.Vars[string(ve)]
-	if !ok {
-		fmt.Fprintf(b.ErrW, "basic: error: variable not found: %s\n", ve)
-		return nil, false
-	}
-	return val, true
+func (ve VarExpr) Compile(c *Compiler) (Type, bool) {
+	slot, t := c.Var(ve.Name)
+	c.Emit(OpLoad, int32(slot))
+	return t, true
+}
+
+// ParenExpr is an expression in parentheses; it is kept so that LIST shows the
+// parentheses which were written.
+type ParenExpr struct {
+	Expr Expr
+}
+
+func (pe ParenExpr) String() string {
+	return "(" + pe.Expr.String() + ")"
+}
+
+func (pe ParenExpr) Print(w io.Writer) {
+	fmt.Fprintf(w, "(%s)", pe.Expr)
+}
+
+func (pe ParenExpr) Compile(c *Compiler) (Type, bool) {
+	return pe.Expr.Compile(c)
 }
 
 type NegateExpr struct {
@@ -327,95 +305,137 @@ func (ne NegateExpr) Print(w io.Writer) {
 	fmt.Fprintf(w, "- %s", ne.Expr)
 }
 
-func (ne NegateExpr) Eval(b *Basic) (interface{}, bool) {
-	val, ok := ne.Expr.Eval(b)
+var negateOpcodes = [NumTypes]Opcode{
+	IntegerType: OpNegateInteger,
+	SingleType:  OpNegateSingle,
+	DoubleType:  OpNegateDouble,
+}
+
+func (ne NegateExpr) Compile(c *Compiler) (Type, bool) {
+	t, ok := ne.Expr.Compile(c)
 	if !ok {
-		return nil, false
+		return NoType, false
 	}
-	n, ok := val.(int)
-	if !ok {
-		fmt.Fprintln(b.ErrW, "basic: error: expected an integer value")
-		return nil, false
+	if !t.Numeric() {
+		c.Error(ErrTypeMismatch.Error())
+		return NoType, false
 	}
-	return -n, true
+	c.Emit(negateOpcodes[t])
+	return t, true
 }
 
+// BinaryOp has an opcode for each type of operand that the operator works for; both operands
+// are converted to the same type before the operator is applied. If Float is set, integer
+// operands are converted to single precision, and if Integer is set, all operands are
+// converted to integers.
 type BinaryOp struct {
-	StringFunc  func(s1, s2 string) (interface{}, bool)
-	IntegerFunc func(n1, n2 int) (interface{}, bool)
+	Name    string
+	Opcodes [NumTypes]Opcode
+	Compare bool
+	Float   bool
+	Integer bool
 }
 
 var BinaryOps = map[string]BinaryOp{
 	"+": {
-		StringFunc: func(s1, s2 string) (interface{}, bool) {
-			return s1 + s2, true
-		},
-		IntegerFunc: func(n1, n2 int) (interface{}, bool) {
-			return n1 + n2, true
+		Name: "+",
+		Opcodes: [NumTypes]Opcode{
+			IntegerType: OpAddInteger,
+			SingleType:  OpAddSingle,
+			DoubleType:  OpAddDouble,
+			StringType:  OpAddString,
 		},
 	},
 	"-": {
-		IntegerFunc: func(n1, n2 int) (interface{}, bool) {
-			return n1 - n2, true
+		Name: "-",
+		Opcodes: [NumTypes]Opcode{
+			IntegerType: OpSubtractInteger,
+			SingleType:  OpSubtractSingle,
+			DoubleType:  OpSubtractDouble,
 		},
 	},
 	"*": {
-		IntegerFunc: func(n1, n2 int) (interface{}, bool) {
-			return n1 * n2, true
+		Name: "*",
+		Opcodes: [NumTypes]Opcode{
+			IntegerType: OpMultiplyInteger,
+			SingleType:  OpMultiplySingle,
+			DoubleType:  OpMultiplyDouble,
 		},
 	},
 	"/": {
-		IntegerFunc: func(n1, n2 int) (interface{}, bool) {
-			return n1 / n2, true
+		Name: "/",
+		Opcodes: [NumTypes]Opcode{
+			SingleType: OpDivideSingle,
+			DoubleType: OpDivideDouble,
 		},
+		Float: true,
 	},
-	"=": {
-		StringFunc: func(s1, s2 string) (interface{}, bool) {
-			return s1 == s2, true
+	"\\": {
+		Name: "\\",
+		Opcodes: [NumTypes]Opcode{
+			IntegerType: OpDivideInteger,
 		},
-		IntegerFunc: func(n1, n2 int) (interface{}, bool) {
-			return n1 == n2, true
+		Integer: true,
+	},
+	"=": {
+		Name: "=",
+		Opcodes: [NumTypes]Opcode{
+			IntegerType: OpEqualInteger,
+			SingleType:  OpEqualFloat,
+			DoubleType:  OpEqualFloat,
+			StringType:  OpEqualString,
 		},
+		Compare: true,
 	},
 	"<>": {
-		StringFunc: func(s1, s2 string) (interface{}, bool) {
-			return s1 != s2, true
-		},
-		IntegerFunc: func(n1, n2 int) (interface{}, bool) {
-			return n1 != n2, true
+		Name: "<>",
+		Opcodes: [NumTypes]Opcode{
+			IntegerType: OpNotEqualInteger,
+			SingleType:  OpNotEqualFloat,
+			DoubleType:  OpNotEqualFloat,
+			StringType:  OpNotEqualString,
 		},
+		Compare: true,
 	},
 	"<": {
-		StringFunc: func(s1, s2 string) (interface{}, bool) {
-			return s1 < s2, true
-		},
-		IntegerFunc: func(n1, n2 int) (interface{}, bool) {
-			return n1 < n2, true
+		Name: "<",
+		Opcodes: [NumTypes]Opcode{
+			IntegerType: OpLessInteger,
+			SingleType:  OpLessFloat,
+			DoubleType:  OpLessFloat,
+			StringType:  OpLessString,
 		},
+		Compare: true,
 	},
 	"<=": {
-		StringFunc: func(s1, s2 string) (interface{}, bool) {
-			return s1 <= s2, true
-		},
-		IntegerFunc: func(n1, n2 int) (interface{}, bool) {
-			return n1 <= n2, true
+		Name: "<=",
+		Opcodes: [NumTypes]Opcode{
+			IntegerType: OpLessEqualInteger,
+			SingleType:  OpLessEqualFloat,
+			DoubleType:  OpLessEqualFloat,
+			StringType:  OpLessEqualString,
 		},
+		Compare: true,
 	},
 	">": {
-		StringFunc: func(s1, s2 string) (interface{}, bool) {
-			return s1 > s2, true
-		},
-		IntegerFunc: func(n1, n2 int) (interface{}, bool) {
-			return n1 > n2, true
+		Name: ">",
+		Opcodes: [NumTypes]Opcode{
+			IntegerType: OpGreaterInteger,
+			SingleType:  OpGreaterFloat,
+			DoubleType:  OpGreaterFloat,
+			StringType:  OpGreaterString,
 		},
+		Compare: true,
 	},
 	">=": {
-		StringFunc: func(s1, s2 string) (interface{}, bool) {
-			return s1 >= s2, true
-		},
-		IntegerFunc: func(n1, n2 int) (interface{}, bool) {
-			return n1 >= n2, true
+		Name: ">=",
+		Opcodes: [NumTypes]Opcode{
+			IntegerType: OpGreaterEqualInteger,
+			SingleType:  OpGreaterEqualFloat,
+			DoubleType:  OpGreaterEqualFloat,
+			StringType:  OpGreaterEqualString,
 		},
+		Compare: true,
 	},
 }
 
@@ -434,47 +454,157 @@ func (be BinaryExpr) Print(w io.Writer) {
 	fmt.Fprintf(w, "%s %s %s", be.Left, be.Name, be.Right)
 }
 
-func (be BinaryExpr) Eval(b *Basic) (interface{}, bool) {
-	val1, ok := be.Left.Eval(b)
+func (be BinaryExpr) Compile(c *Compiler) (Type, bool) {
+	lt, ok := be.Left.Compile(c)
 	if !ok {
-		return nil, false
+		return NoType, false
 	}
-	val2, ok := be.Right.Eval(b)
+	pc := c.PC()
+	rt, ok := be.Right.Compile(c)
 	if !ok {
-		return nil, false
+		return NoType, false
 	}
 
-	switch v1 := val1.(type) {
-	case int:
-		if be.Op.IntegerFunc == nil {
-			fmt.Fprintf(b.ErrW, "basic: error: %s does not work for integers\n", be.Name)
-			return nil, false
+	t := lt
+	if lt.Numeric() {
+		if !rt.Numeric() {
+			c.Error(ErrTypeMismatch.Error())
+			return NoType, false
 		}
-		n2, ok := val2.(int)
-		if !ok {
-			fmt.Fprintln(b.ErrW, "basic: error: expected an integer value")
-			return nil, false
+		if rt > t {
+			t = rt
 		}
-		return be.Op.IntegerFunc(v1, n2)
-	case string:
-		if be.Op.StringFunc == nil {
-			fmt.Fprintf(b.ErrW, "basic: error: %s does not work for strings\n", be.Name)
-			return nil, false
+		if be.Op.Float && t == IntegerType {
+			t = SingleType
+		} else if be.Op.Integer {
+			t = IntegerType
+		}
+		c.Convert(pc, lt, t)
+		c.Convert(c.PC(), rt, t)
+	} else if lt == StringType {
+		if be.Op.Opcodes[StringType] == 0 {
+			c.Error(ErrTypeMismatch.Error())
+			return NoType, false
+		}
+		if rt != StringType {
+			c.Error(ErrTypeMismatch.Error())
+			return NoType, false
+		}
+	} else {
+		c.Error(ErrTypeMismatch.Error())
+		return NoType, false
+	}
+
+	c.Emit(be.Op.Opcodes[t])
+	if be.Op.Compare {
+		return BooleanType, true
+	}
+	return t, true
+}
+
+// Function is a builtin function: the arguments are converted to the types in Args and then
+// Opcode is applied to them.
+type Function struct {
+	Name   string
+	Args   []Type
+	Result Type
+	Opcode Opcode
+}
+
+var Functions = map[string]Function{
+	"EOF":  {Name: "EOF", Args: []Type{IntegerType}, Result: BooleanType, Opcode: OpEOF},
+	"LOC":  {Name: "LOC", Args: []Type{IntegerType}, Result: SingleType, Opcode: OpLOC},
+	"LOF":  {Name: "LOF", Args: []Type{IntegerType}, Result: SingleType, Opcode: OpLOF},
+	"MKI$": {Name: "MKI$", Args: []Type{IntegerType}, Result: StringType, Opcode: OpMKI},
+	"MKS$": {Name: "MKS$", Args: []Type{SingleType}, Result: StringType, Opcode: OpMKS},
+	"MKD$": {Name: "MKD$", Args: []Type{DoubleType}, Result: StringType, Opcode: OpMKD},
+	"CVI":  {Name: "CVI", Args: []Type{StringType}, Result: IntegerType, Opcode: OpCVI},
+	"CVS":  {Name: "CVS", Args: []Type{StringType}, Result: SingleType, Opcode: OpCVS},
+	"CVD":  {Name: "CVD", Args: []Type{StringType}, Result: DoubleType, Opcode: OpCVD},
+	"CHR$": {Name: "CHR$", Args: []Type{IntegerType}, Result: StringType, Opcode: OpChr},
+}
+
+type CallExpr struct {
+	Func Function
+	Args []Expr
+}
+
+func (ce CallExpr) String() string {
+	args := make([]string, len(ce.Args))
+	for i, arg := range ce.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", ce.Func.Name, strings.Join(args, ", "))
+}
+
+func (ce CallExpr) Print(w io.Writer) {
+	fmt.Fprintf(w, "%s(", ce.Func.Name)
+	for i, arg := range ce.Args {
+		if i > 0 {
+			fmt.Fprint(w, ", ")
 		}
-		s2, ok := val2.(string)
+		arg.Print(w)
+	}
+	fmt.Fprint(w, ")")
+}
+
+func (ce CallExpr) Compile(c *Compiler) (Type, bool) {
+	for i, arg := range ce.Args {
+		t, ok := arg.Compile(c)
 		if !ok {
-			fmt.Fprintln(b.ErrW, "basic: error: expected a string value")
-			return nil, false
+			return NoType, false
 		}
-		return be.Op.StringFunc(v1, s2)
+		if ce.Func.Args[i] == StringType {
+			if t != StringType {
+				c.Error(ErrTypeMismatch.Error())
+				return NoType, false
+			}
+		} else if !t.Numeric() {
+			c.Error(ErrTypeMismatch.Error())
+			return NoType, false
+		} else {
+			c.Convert(c.PC(), t, ce.Func.Args[i])
+		}
+	}
+	c.Emit(ce.Func.Opcode)
+	return ce.Func.Result, true
+}
 
-	case bool:
-		fmt.Fprintf(b.ErrW, "basic: error: %s does not work for booleans\n", be.Name)
-		return nil, false
+// floatValue converts a number with a decimal point, an exponent, or a ! or # suffix, or
+// which is too big to be an integer, to a single precision value. The value is double
+// precision if it has a # suffix, a D exponent, or more than seven digits without a !
+// suffix. It fails with ErrOverflow if the number is too big for its type.
+func floatValue(s string) (Value, error) {
+	double, single := false, false
+	if strings.HasSuffix(s, "#") {
+		double = true
+		s = s[:len(s)-1]
+	} else if strings.HasSuffix(s, "!") {
+		single = true
+		s = s[:len(s)-1]
+	}
+	mantissa := s
+	if i := strings.IndexAny(s, "ED"); i >= 0 {
+		if s[i] == 'D' {
+			double = true
+			s = s[:i] + "E" + s[i+1:]
+		}
+		mantissa = s[:i]
+	}
 
-	default:
-		panic("unexpected value type")
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		if !errors.Is(err, strconv.ErrRange) {
+			return Value{}, err
+		} else if f != 0 {
+			return Value{}, ErrOverflow
+		}
 	}
+	digits := strings.TrimLeft(strings.Replace(mantissa, ".", "", 1), "0")
+	if double || (!single && len(digits) > 7) {
+		return DoubleValue(f), nil
+	}
+	return NumberValue(f, SingleType)
 }
 
 func (b *Basic) CompileExpr(tr *TokenReader) (Expr, bool) {
@@ -482,11 +612,48 @@ func (b *Basic) CompileExpr(tr *TokenReader) (Expr, bool) {
 
 	t, n, s := tr.ReadToken()
 	if t == IntegerToken {
-		e = ValueExpr{n}
+		if n > MaxInteger {
+			val, err := floatValue(strconv.Itoa(n))
+			if err != nil {
+				b.Error(tr, fmt.Sprintf("basic: error: bad number: %d", n))
+				return nil, false
+			}
+			e = ValueExpr{Value: val, Text: s}
+		} else {
+			e = ValueExpr{Value: IntegerValue(n), Text: s}
+		}
+	} else if t == FloatToken {
+		val, err := floatValue(s)
+		if err == ErrOverflow {
+			b.Error(tr, "basic: error: Overflow")
+			return nil, false
+		} else if err != nil {
+			b.Error(tr, fmt.Sprintf("basic: error: bad number: %s", s))
+			return nil, false
+		}
+		e = ValueExpr{Value: val, Text: s}
 	} else if t == StringToken {
-		e = ValueExpr{s}
+		e = ValueExpr{Value: StringValue(s)}
+	} else if t == ErrorToken {
+		b.Error(tr, "basic: error: "+s)
+		return nil, false
+	} else if fn, ok := Functions[s]; ok && t == KeywordToken {
+		args, ok := b.compileArgs(tr, fn.Name)
+		if !ok {
+			return nil, false
+		}
+		if len(args) != len(fn.Args) {
+			b.Error(tr, fmt.Sprintf("basic: error: %s expects %d argument(s)", fn.Name,
+				len(fn.Args)))
+			return nil, false
+		}
+		e = CallExpr{fn, args}
 	} else if t == KeywordToken {
-		e = VarExpr(s)
+		if Keywords[s] {
+			b.Error(tr, "basic: error: Syntax error")
+			return nil, false
+		}
+		e = VarExpr{s}
 	} else if t == OperatorToken && s == "-" {
 		var ok bool
 		e, ok = b.CompileExpr(tr)
@@ -505,6 +672,7 @@ func (b *Basic) CompileExpr(tr *TokenReader) (Expr, bool) {
 			b.Error(tr, "basic: error: missing closing ) in expression")
 			return nil, false
 		}
+		e = ParenExpr{e}
 	} else {
 		b.Error(tr, "basic: error: unexpected token in expression")
 		return nil, false
@@ -530,6 +698,32 @@ func (b *Basic) CompileExpr(tr *TokenReader) (Expr, bool) {
 	return e, true
 }
 
+// compileArgs compiles the parenthesized arguments of a function.
+func (b *Basic) compileArgs(tr *TokenReader, name string) ([]Expr, bool) {
+	t, _, s := tr.ReadToken()
+	if t != OperatorToken || s != "(" {
+		b.Error(tr, fmt.Sprintf("basic: error: expected ( following %s", name))
+		return nil, false
+	}
+
+	var args []Expr
+	for {
+		e, ok := b.CompileExpr(tr)
+		if !ok {
+			return nil, false
+		}
+		args = append(args, e)
+
+		t, _, s = tr.ReadToken()
+		if t == OperatorToken && s == ")" {
+			return args, true
+		} else if t != OperatorToken || s != "," {
+			b.Error(tr, fmt.Sprintf("basic: error: missing closing ) for %s", name))
+			return nil, false
+		}
+	}
+}
+
 const (
 	GoSubCtx = iota
 	ForCtx
@@ -542,346 +736,1385 @@ type Ctx struct {
 }
 
 type Stmt interface {
-	Execute(b *Basic, ln int, stk []Ctx) (int, []Ctx)
+	Compile(c *Compiler) bool
 	Print(w io.Writer)
 }
 
-type EndStmt struct{}
+// EndStmt is END or SYSTEM, which end the program; SYSTEM also leaves BASIC. Status, if it is
+// not nil, is the exit status of the program.
+type EndStmt struct {
+	System bool
+	Status Expr
+}
 
-func (_ EndStmt) Execute(b *Basic, ln int, stk []Ctx) (int, []Ctx) {
-	return -1, stk
+func (es EndStmt) Compile(c *Compiler) bool {
+	if es.Status == nil && !es.System {
+		c.Emit(OpEnd)
+		return true
+	}
+	if es.Status == nil {
+		c.EmitConst(IntegerValue(0))
+	} else if !c.CompileInteger(es.Status) {
+		return false
+	}
+	c.Emit(OpExit, int32(boolInt(es.System)))
+	return true
 }
 
-func (_ EndStmt) Print(w io.Writer) {
-	fmt.Fprint(w, "END")
+func (es EndStmt) Print(w io.Writer) {
+	if es.System {
+		fmt.Fprint(w, "SYSTEM")
+	} else {
+		fmt.Fprint(w, "END")
+	}
+	if es.Status != nil {
+		fmt.Fprint(w, " ")
+		es.Status.Print(w)
+	}
 }
 
-type GoSubStmt int
+type GoSubStmt struct {
+	Number int
+}
 
-func (gs GoSubStmt) Execute(b *Basic, ln int, stk []Ctx) (int, []Ctx) {
-	return int(gs), append(stk, Ctx{GoSubCtx, ln})
+func (gs GoSubStmt) Compile(c *Compiler) bool {
+	c.EmitLine(OpGoSub, gs.Number)
+	return true
 }
 
 func (gs GoSubStmt) Print(w io.Writer) {
-	fmt.Fprintf(w, "GOSUB %d", int(gs))
+	fmt.Fprintf(w, "GOSUB %d", gs.Number)
 }
 
 type ReturnStmt struct{}
 
-func (_ ReturnStmt) Execute(b *Basic, ln int, stk []Ctx) (int, []Ctx) {
-	for len(stk) > 0 {
-		ctx := stk[len(stk)-1]
-		stk = stk[:len(stk)-1]
-		if ctx.Type == GoSubCtx {
-			return ctx.Number + 1, stk
-		}
-	}
-
-	fmt.Fprintln(b.ErrW, "basic: error: RETURN without a GOSUB")
-	return -1, stk
+func (_ ReturnStmt) Compile(c *Compiler) bool {
+	c.Emit(OpReturn)
+	return true
 }
 
 func (_ ReturnStmt) Print(w io.Writer) {
 	fmt.Fprint(w, "RETURN")
 }
 
-type GotoStmt int
+type GotoStmt struct {
+	Number int
+}
 
-func (gs GotoStmt) Execute(b *Basic, ln int, stk []Ctx) (int, []Ctx) {
-	return int(gs), stk
+func (gs GotoStmt) Compile(c *Compiler) bool {
+	c.EmitLine(OpJump, gs.Number)
+	return true
 }
 
 func (gs GotoStmt) Print(w io.Writer) {
-	fmt.Fprintf(w, "GOTO %d", int(gs))
+	fmt.Fprintf(w, "GOTO %d", gs.Number)
 }
 
 type RemStmt string
 
-func (_ RemStmt) Execute(b *Basic, ln int, stk []Ctx) (int, []Ctx) {
-	return ln + 1, stk
+func (_ RemStmt) Compile(c *Compiler) bool {
+	return true
 }
 
 func (rs RemStmt) Print(w io.Writer) {
-	fmt.Fprint(w, "REM ")
+	fmt.Fprint(w, "REM")
 	fmt.Fprint(w, string(rs))
 }
 
+// CommentStmt is a statement followed by a ' comment, or just a comment if Stmt is nil;
+// Comment starts with the '.
+type CommentStmt struct {
+	Stmt    Stmt
+	Comment string
+}
+
+func (cs CommentStmt) Compile(c *Compiler) bool {
+	if cs.Stmt == nil {
+		return true
+	}
+	return cs.Stmt.Compile(c)
+}
+
+func (cs CommentStmt) Print(w io.Writer) {
+	if cs.Stmt != nil {
+		cs.Stmt.Print(w)
+		fmt.Fprint(w, " ")
+	}
+	fmt.Fprint(w, cs.Comment)
+}
+
+// AssignStmt is [LET] variable = expression; Let is set if it was written with LET.
 type AssignStmt struct {
 	Var  string
 	Expr Expr
+	Let  bool
 }
 
-func (as AssignStmt) Execute(b *Basic, ln int, stk []Ctx) (int, []Ctx) {
-	val, ok := as.Expr.Eval(b)
+func (as AssignStmt) Compile(c *Compiler) bool {
+	t, ok := as.Expr.Compile(c)
 	if !ok {
-		return -1, stk
+		return false
 	}
 
-	if as.Var[len(as.Var)-1] == '$' {
-		if _, ok := val.(string); !ok {
-			fmt.Fprintln(b.ErrW, "basic: error: expected a string value")
-			return -1, stk
-		}
-	} else if as.Var[len(as.Var)-1] == '%' {
-		if _, ok := val.(int); !ok {
-			fmt.Fprintln(b.ErrW, "basic: error: expected an integer value")
-			return -1, stk
+	slot, vt := c.Var(as.Var)
+	if vt == StringType {
+		if t != StringType {
+			c.Error(ErrTypeMismatch.Error())
+			return false
 		}
+	} else if !t.Numeric() {
+		c.Error(ErrTypeMismatch.Error())
+		return false
 	} else {
-		panic("not a string or integer variable")
+		c.Convert(c.PC(), t, vt)
 	}
-
-	b.Vars[as.Var] = val
-	return ln + 1, stk
+	c.Emit(OpStore, int32(slot))
+	return true
 }
 
 func (as AssignStmt) Print(w io.Writer) {
+	if as.Let {
+		fmt.Fprint(w, "LET ")
+	}
 	fmt.Fprintf(w, "%s = ", as.Var)
 	as.Expr.Print(w)
 
 }
 
-type PrintStmt struct {
-	exprs []Expr
+// DefStmt is DEFINT, DEFSNG, DEFDBL, or DEFSTR, which set the type of variables without a
+// type suffix by the first letter of their names. Since types are checked when a program is
+// compiled, a DefStmt applies to the lines after it, rather than to the statements executed
+// after it.
+type DefStmt struct {
+	Type   Type
+	Ranges []LetterRange
 }
 
-func (ps PrintStmt) Execute(b *Basic, ln int, stk []Ctx) (int, []Ctx) {
-	for i, e := range ps.exprs {
-		if i > 0 {
-			fmt.Fprint(b.W, ", ")
-		}
-		val, ok := e.Eval(b)
-		if !ok {
-			return -1, stk
-		}
-		switch v := val.(type) {
-		case int:
-			fmt.Fprintf(b.W, "%d", v)
-		case string:
-			fmt.Fprint(b.W, v)
-		case bool:
-			if v {
-				fmt.Fprint(b.W, "TRUE")
-			} else {
-				fmt.Fprint(b.W, "FALSE")
-			}
-		default:
-			panic("unexpected value type")
+// LetterRange is a range of first letters, from First to Last inclusive.
+type LetterRange struct {
+	First byte
+	Last  byte
+}
+
+var defKeywords = map[Type]string{
+	IntegerType: "DEFINT",
+	SingleType:  "DEFSNG",
+	DoubleType:  "DEFDBL",
+	StringType:  "DEFSTR",
+}
+
+func (ds DefStmt) Compile(c *Compiler) bool {
+	ds.Apply(&c.b.DefTypes)
+	return true
+}
+
+// Apply sets the type of each letter in the ranges in defTypes.
+func (ds DefStmt) Apply(defTypes *[26]Type) {
+	for _, r := range ds.Ranges {
+		for l := r.First; l <= r.Last; l += 1 {
+			defTypes[l-'A'] = ds.Type
 		}
 	}
-	fmt.Fprintln(b.W)
-	return ln + 1, stk
 }
 
-func (ps PrintStmt) Print(w io.Writer) {
-	fmt.Fprint(w, "PRINT ")
-	for i, e := range ps.exprs {
+func (ds DefStmt) Print(w io.Writer) {
+	fmt.Fprint(w, defKeywords[ds.Type])
+	for i, r := range ds.Ranges {
 		if i > 0 {
-			fmt.Fprint(w, ", ")
+			fmt.Fprint(w, ",")
+		}
+		if r.First == r.Last {
+			fmt.Fprintf(w, " %c", r.First)
+		} else {
+			fmt.Fprintf(w, " %c-%c", r.First, r.Last)
 		}
-		e.Print(w)
 	}
 }
 
-type IfThenStmt struct {
-	Test Expr
-	Then Stmt
-	Else Stmt
-}
+const (
+	PrintExpr = iota
+	PrintComma
+	PrintSemicolon
+	PrintTab
+	PrintSpc
+)
 
-func (its IfThenStmt) Execute(b *Basic, ln int, stk []Ctx) (int, []Ctx) {
-	val, ok := its.Test.Eval(b)
-	if !ok {
-		return -1, stk
-	}
-	t, ok := val.(bool)
-	if !ok {
-		fmt.Fprintln(b.ErrW, "basic: error: expected a boolean value")
-		return -1, stk
-	}
-	if t {
-		return its.Then.Execute(b, ln, stk)
-	} else if its.Else != nil {
-		return its.Else.Execute(b, ln, stk)
-	}
-	return ln + 1, stk
+type PrintItem struct {
+	Kind int
+	Expr Expr
 }
 
-func (its IfThenStmt) Print(w io.Writer) {
-	fmt.Fprintf(w, "IF %s THEN ", its.Test)
-	its.Then.Print(w)
-	if its.Else != nil {
-		fmt.Fprint(w, " ELSE ")
-		its.Else.Print(w)
-	}
+// PrintStmt is PRINT, or PRINT USING if Using is not nil; the items of PRINT USING are
+// only expressions and separators. The output goes to a file if File is not nil.
+type PrintStmt struct {
+	File  Expr
+	Using Expr
+	Items []PrintItem
 }
 
-type IfGotoStmt struct {
-	Test   Expr
-	Number int
+// compileFile compiles the file number of a statement and selects the file using op.
+func compileFile(c *Compiler, e Expr, op Opcode) bool {
+	if !c.CompileInteger(e) {
+		return false
+	}
+	c.Emit(op)
+	return true
 }
 
-func (igs IfGotoStmt) Execute(b *Basic, ln int, stk []Ctx) (int, []Ctx) {
-	val, ok := igs.Test.Eval(b)
-	if !ok {
-		return -1, stk
+func (ps PrintStmt) Compile(c *Compiler) bool {
+	if ps.File != nil && !compileFile(c, ps.File, OpOutputFile) {
+		return false
 	}
-	t, ok := val.(bool)
-	if !ok {
-		fmt.Fprintln(b.ErrW, "basic: error: expected a boolean value")
-		return -1, stk
+
+	var ok bool
+	if ps.Using != nil {
+		ok = ps.compileUsing(c)
+	} else {
+		ok = ps.compileItems(c)
 	}
-	if t {
-		return igs.Number, stk
+	if ps.File != nil {
+		c.Emit(OpScreen)
 	}
-	return ln + 1, stk
+	return ok
 }
 
-func (igs IfGotoStmt) Print(w io.Writer) {
-	fmt.Fprintf(w, "IF %s GOTO %d", igs.Test, igs.Number)
+func (ps PrintStmt) compileItems(c *Compiler) bool {
+	for _, item := range ps.Items {
+		switch item.Kind {
+		case PrintExpr:
+			if _, ok := item.Expr.Compile(c); !ok {
+				return false
+			}
+			c.Emit(OpPrint)
+		case PrintComma:
+			c.Emit(OpPrintComma)
+		case PrintTab, PrintSpc:
+			if !c.CompileInteger(item.Expr) {
+				return false
+			}
+			if item.Kind == PrintTab {
+				c.Emit(OpPrintTab)
+			} else {
+				c.Emit(OpPrintSpc)
+			}
+		}
+	}
+
+	ps.compileNewline(c)
+	return true
+}
+
+func (ps PrintStmt) compileNewline(c *Compiler) {
+	if len(ps.Items) == 0 {
+		c.Emit(OpPrintNewline)
+	} else if kind := ps.Items[len(ps.Items)-1].Kind; kind != PrintComma &&
+		kind != PrintSemicolon {
+
+		c.Emit(OpPrintNewline)
+	}
+}
+
+func (ps PrintStmt) compileUsing(c *Compiler) bool {
+	t, ok := ps.Using.Compile(c)
+	if !ok {
+		return false
+	}
+	if t != StringType {
+		c.Error(ErrTypeMismatch.Error())
+		return false
+	}
+
+	var n int32
+	for _, item := range ps.Items {
+		if item.Kind == PrintExpr {
+			if _, ok := item.Expr.Compile(c); !ok {
+				return false
+			}
+			n += 1
+		}
+	}
+	c.Emit(OpPrintUsing, n)
+	ps.compileNewline(c)
+	return true
+}
+
+func (ps PrintStmt) Print(w io.Writer) {
+	fmt.Fprint(w, "PRINT")
+	if ps.File != nil {
+		fmt.Fprintf(w, " #%s,", ps.File)
+	}
+	if ps.Using != nil {
+		fmt.Fprint(w, " USING ")
+		ps.Using.Print(w)
+		fmt.Fprint(w, ";")
+	}
+	for _, item := range ps.Items {
+		switch item.Kind {
+		case PrintExpr:
+			fmt.Fprint(w, " ")
+			item.Expr.Print(w)
+		case PrintComma:
+			fmt.Fprint(w, ",")
+		case PrintSemicolon:
+			fmt.Fprint(w, ";")
+		case PrintTab:
+			fmt.Fprint(w, " TAB(")
+			item.Expr.Print(w)
+			fmt.Fprint(w, ")")
+		case PrintSpc:
+			fmt.Fprint(w, " SPC(")
+			item.Expr.Print(w)
+			fmt.Fprint(w, ")")
+		}
+	}
+}
+
+// WriteStmt is WRITE, or WRITE # if File is not nil.
+type WriteStmt struct {
+	File  Expr
+	Exprs []Expr
+}
+
+func (ws WriteStmt) Compile(c *Compiler) bool {
+	if ws.File != nil && !compileFile(c, ws.File, OpOutputFile) {
+		return false
+	}
+	for i, e := range ws.Exprs {
+		if i > 0 {
+			c.Emit(OpWriteComma)
+		}
+		if _, ok := e.Compile(c); !ok {
+			return false
+		}
+		c.Emit(OpWrite)
+	}
+	c.Emit(OpPrintNewline)
+	if ws.File != nil {
+		c.Emit(OpScreen)
+	}
+	return true
+}
+
+func (ws WriteStmt) Print(w io.Writer) {
+	fmt.Fprint(w, "WRITE")
+	if ws.File != nil {
+		fmt.Fprintf(w, " #%s,", ws.File)
+	}
+	for i, e := range ws.Exprs {
+		if i > 0 {
+			fmt.Fprint(w, ",")
+		}
+		fmt.Fprint(w, " ")
+		e.Print(w)
+	}
+}
+
+// OpenStmt is OPEN mode, #number, name [, length]; For is set if it was written as
+// OPEN name FOR mode AS #number, where mode is INPUT, OUTPUT, or APPEND. Only the first
+// letter of the mode matters. Length is the record length of a random file.
+type OpenStmt struct {
+	Mode   Expr
+	Number Expr
+	Name   Expr
+	Length Expr
+	For    bool
+}
+
+func (ops OpenStmt) Compile(c *Compiler) bool {
+	if !c.CompileString(ops.Mode) || !c.CompileInteger(ops.Number) ||
+		!c.CompileString(ops.Name) {
+
+		return false
+	}
+	if ops.Length == nil {
+		c.EmitConst(IntegerValue(0))
+	} else if !c.CompileInteger(ops.Length) {
+		return false
+	}
+	c.Emit(OpOpen)
+	return true
+}
+
+func (ops OpenStmt) Print(w io.Writer) {
+	fmt.Fprint(w, "OPEN ")
+	if ops.For {
+		ops.Name.Print(w)
+		fmt.Fprintf(w, " FOR %s AS #%s", ops.Mode.(ValueExpr).Value.String, ops.Number)
+	} else {
+		ops.Mode.Print(w)
+		fmt.Fprintf(w, ", #%s, ", ops.Number)
+		ops.Name.Print(w)
+		if ops.Length != nil {
+			fmt.Fprintf(w, ", %s", ops.Length)
+		}
+	}
+}
+
+// CloseStmt closes the files in Numbers, or all of the files if there are none.
+type CloseStmt struct {
+	Numbers []Expr
+}
+
+func (cs CloseStmt) Compile(c *Compiler) bool {
+	if len(cs.Numbers) == 0 {
+		c.Emit(OpCloseAll)
+	}
+	for _, e := range cs.Numbers {
+		if !compileFile(c, e, OpClose) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cs CloseStmt) Print(w io.Writer) {
+	fmt.Fprint(w, "CLOSE")
+	for i, e := range cs.Numbers {
+		if i > 0 {
+			fmt.Fprint(w, ",")
+		}
+		fmt.Fprintf(w, " #%s", e)
+	}
+}
+
+// KillStmt is KILL name, which deletes a file.
+type KillStmt struct {
+	Name Expr
+}
+
+func (ks KillStmt) Compile(c *Compiler) bool {
+	if !c.CompileString(ks.Name) {
+		return false
+	}
+	c.Emit(OpKill)
+	return true
+}
+
+func (ks KillStmt) Print(w io.Writer) {
+	fmt.Fprint(w, "KILL ")
+	ks.Name.Print(w)
+}
+
+// AssertStmt is ASSERT test [, message], which stops the program with an error, and fails the
+// test being run, if test is false.
+type AssertStmt struct {
+	Test    Expr
+	Message Expr
+}
+
+func (as AssertStmt) Compile(c *Compiler) bool {
+	if !c.CompileTest(as.Test) {
+		return false
+	}
+	if as.Message != nil {
+		if !c.CompileString(as.Message) {
+			return false
+		}
+	} else {
+		c.EmitConst(StringValue(""))
+	}
+	c.Emit(OpAssert)
+	return true
+}
+
+func (as AssertStmt) Print(w io.Writer) {
+	fmt.Fprint(w, "ASSERT ")
+	as.Test.Print(w)
+	if as.Message != nil {
+		fmt.Fprint(w, ", ")
+		as.Message.Print(w)
+	}
+}
+
+// NameStmt is NAME old AS new, which renames a file.
+type NameStmt struct {
+	Old Expr
+	New Expr
+}
+
+func (ns NameStmt) Compile(c *Compiler) bool {
+	if !c.CompileString(ns.Old) || !c.CompileString(ns.New) {
+		return false
+	}
+	c.Emit(OpName)
+	return true
+}
+
+func (ns NameStmt) Print(w io.Writer) {
+	fmt.Fprint(w, "NAME ")
+	ns.Old.Print(w)
+	fmt.Fprint(w, " AS ")
+	ns.New.Print(w)
+}
+
+// FilesStmt is FILES [pattern], which lists the files matching Pattern, or all files if
+// Pattern is nil.
+type FilesStmt struct {
+	Pattern Expr
+}
+
+func (fls FilesStmt) Compile(c *Compiler) bool {
+	if fls.Pattern == nil {
+		c.EmitConst(StringValue(""))
+	} else if !c.CompileString(fls.Pattern) {
+		return false
+	}
+	c.Emit(OpFiles)
+	return true
+}
+
+func (fls FilesStmt) Print(w io.Writer) {
+	fmt.Fprint(w, "FILES")
+	if fls.Pattern != nil {
+		fmt.Fprint(w, " ")
+		fls.Pattern.Print(w)
+	}
+}
+
+// InputStmt is INPUT #, which reads items from a file, or INPUT, which reads a line of items
+// from the keyboard after the prompt, if there is one, and a ? unless Comma follows the prompt.
+type InputStmt struct {
+	File   Expr
+	Prompt *string
+	Comma  bool
+	Vars   []VarExpr
+}
+
+func (is InputStmt) Compile(c *Compiler) bool {
+	if is.File == nil {
+		prompt := "? "
+		if is.Prompt != nil {
+			prompt = *is.Prompt
+			if !is.Comma {
+				prompt += "? "
+			}
+		}
+		c.EmitConst(StringValue(prompt))
+		c.Emit(OpKeyboard)
+	} else if !compileFile(c, is.File, OpInputFile) {
+		return false
+	}
+	for _, v := range is.Vars {
+		slot, t := c.Var(v.Name)
+		c.Emit(OpReadItem, int32(t))
+		c.Emit(OpStore, int32(slot))
+	}
+	return true
+}
+
+func (is InputStmt) Print(w io.Writer) {
+	if is.File != nil {
+		fmt.Fprintf(w, "INPUT #%s,", is.File)
+	} else if is.Prompt != nil {
+		sep := ";"
+		if is.Comma {
+			sep = ","
+		}
+		fmt.Fprintf(w, "INPUT %s%s", ValueExpr{Value: StringValue(*is.Prompt)}, sep)
+	} else {
+		fmt.Fprint(w, "INPUT")
+	}
+	for i, v := range is.Vars {
+		if i > 0 {
+			fmt.Fprint(w, ",")
+		}
+		fmt.Fprintf(w, " %s", v.Name)
+	}
+}
+
+// LineInputStmt is LINE INPUT #, which reads a whole line from a file into a string variable,
+// or LINE INPUT, which reads a line from the keyboard after the prompt, if there is one.
+type LineInputStmt struct {
+	File   Expr
+	Prompt *string
+	Var    VarExpr
+}
+
+func (lis LineInputStmt) Compile(c *Compiler) bool {
+	slot, ok := c.StringVar(lis.Var)
+	if !ok {
+		return false
+	}
+	if lis.File == nil {
+		var prompt string
+		if lis.Prompt != nil {
+			prompt = *lis.Prompt
+		}
+		c.EmitConst(StringValue(prompt))
+		c.Emit(OpKeyboard)
+	} else if !compileFile(c, lis.File, OpInputFile) {
+		return false
+	}
+	c.Emit(OpReadLine)
+	c.Emit(OpStore, int32(slot))
+	return true
+}
+
+func (lis LineInputStmt) Print(w io.Writer) {
+	if lis.File != nil {
+		fmt.Fprintf(w, "LINE INPUT #%s, %s", lis.File, lis.Var.Name)
+	} else if lis.Prompt != nil {
+		fmt.Fprintf(w, "LINE INPUT %s; %s", ValueExpr{Value: StringValue(*lis.Prompt)},
+			lis.Var.Name)
+	} else {
+		fmt.Fprintf(w, "LINE INPUT %s", lis.Var.Name)
+	}
+}
+
+type FieldItem struct {
+	Length Expr
+	Var    VarExpr
+}
+
+// FieldStmt makes string variables aliases for consecutive parts of the record buffer of a
+// random file.
+type FieldStmt struct {
+	File  Expr
+	Items []FieldItem
+}
+
+func (fs FieldStmt) Compile(c *Compiler) bool {
+	if !compileFile(c, fs.File, OpRandomFile) {
+		return false
+	}
+	for _, item := range fs.Items {
+		slot, ok := c.StringVar(item.Var)
+		if !ok || !c.CompileInteger(item.Length) {
+			return false
+		}
+		c.Emit(OpField, int32(slot))
+	}
+	return true
+}
+
+func (fs FieldStmt) Print(w io.Writer) {
+	fmt.Fprintf(w, "FIELD #%s,", fs.File)
+	for i, item := range fs.Items {
+		if i > 0 {
+			fmt.Fprint(w, ",")
+		}
+		fmt.Fprintf(w, " %s AS %s", item.Length, item.Var.Name)
+	}
+}
+
+// GetPutStmt is GET, or PUT if Put is set, of a record of a random file; if Record is nil,
+// the next record is used.
+type GetPutStmt struct {
+	Put    bool
+	File   Expr
+	Record Expr
+}
+
+func (gps GetPutStmt) Compile(c *Compiler) bool {
+	if !compileFile(c, gps.File, OpRandomFile) {
+		return false
+	}
+	var arg int32
+	if gps.Record != nil {
+		if !c.CompileInteger(gps.Record) {
+			return false
+		}
+		arg = 1
+	}
+	if gps.Put {
+		c.Emit(OpPut, arg)
+	} else {
+		c.Emit(OpGet, arg)
+	}
+	return true
+}
+
+func (gps GetPutStmt) Print(w io.Writer) {
+	if gps.Put {
+		fmt.Fprint(w, "PUT")
+	} else {
+		fmt.Fprint(w, "GET")
+	}
+	fmt.Fprintf(w, " #%s", gps.File)
+	if gps.Record != nil {
+		fmt.Fprintf(w, ", %s", gps.Record)
+	}
+}
+
+// SetStmt is LSET, or RSET if Right is set.
+type SetStmt struct {
+	Right bool
+	Var   VarExpr
+	Expr  Expr
+}
+
+func (ss SetStmt) Compile(c *Compiler) bool {
+	slot, ok := c.StringVar(ss.Var)
+	if !ok || !c.CompileString(ss.Expr) {
+		return false
+	}
+	if ss.Right {
+		c.Emit(OpRSet, int32(slot))
+	} else {
+		c.Emit(OpLSet, int32(slot))
+	}
+	return true
+}
+
+func (ss SetStmt) Print(w io.Writer) {
+	if ss.Right {
+		fmt.Fprint(w, "RSET ")
+	} else {
+		fmt.Fprint(w, "LSET ")
+	}
+	fmt.Fprintf(w, "%s = ", ss.Var.Name)
+	ss.Expr.Print(w)
+}
+
+type WidthStmt struct {
+	Expr Expr
+}
+
+func (ws WidthStmt) Compile(c *Compiler) bool {
+	if !c.CompileInteger(ws.Expr) {
+		return false
+	}
+	c.Emit(OpWidth)
+	return true
+}
+
+func (ws WidthStmt) Print(w io.Writer) {
+	fmt.Fprint(w, "WIDTH ")
+	ws.Expr.Print(w)
+}
+
+type IfThenStmt struct {
+	Test Expr
+	Then Stmt
+	Else Stmt
+}
+
+func (its IfThenStmt) Compile(c *Compiler) bool {
+	if !c.CompileTest(its.Test) {
+		return false
+	}
+	els := c.EmitJump(OpJumpFalse)
+	if !its.Then.Compile(c) {
+		return false
+	}
+	if its.Else != nil {
+		end := c.EmitJump(OpJump)
+		c.Patch(els)
+		if !its.Else.Compile(c) {
+			return false
+		}
+		c.Patch(end)
+	} else {
+		c.Patch(els)
+	}
+	return true
+}
+
+func (its IfThenStmt) Print(w io.Writer) {
+	fmt.Fprintf(w, "IF %s THEN ", its.Test)
+	its.Then.Print(w)
+	if its.Else != nil {
+		fmt.Fprint(w, " ELSE ")
+		its.Else.Print(w)
+	}
+}
+
+type IfGotoStmt struct {
+	Test   Expr
+	Number int
+}
+
+func (igs IfGotoStmt) Compile(c *Compiler) bool {
+	if !c.CompileTest(igs.Test) {
+		return false
+	}
+	skip := c.EmitJump(OpJumpFalse)
+	c.EmitLine(OpJump, igs.Number)
+	c.Patch(skip)
+	return true
+}
+
+func (igs IfGotoStmt) Print(w io.Writer) {
+	fmt.Fprintf(w, "IF %s GOTO %d", igs.Test, igs.Number)
+}
+
+// compileFileNumber compiles the file number of a statement, which may follow a #. If comma
+// is set, the file number must be followed by a comma.
+func (b *Basic) compileFileNumber(tr *TokenReader, kw string, comma bool) (Expr, bool) {
+	t, _, s := tr.PeekToken()
+	if t == OperatorToken && s == "#" {
+		tr.ReadToken()
+	}
+	e, ok := b.CompileExpr(tr)
+	if !ok {
+		return nil, false
+	}
+	if comma {
+		t, _, s = tr.ReadToken()
+		if t != OperatorToken || s != "," {
+			b.Error(tr, fmt.Sprintf("basic: error: expected , following file number for %s", kw))
+			return nil, false
+		}
+	}
+	return e, true
+}
+
+// compileInputVar compiles a variable which INPUT # or LINE INPUT # reads into.
+func (b *Basic) compileInputVar(tr *TokenReader, kw string) (VarExpr, bool) {
+	t, _, s := tr.ReadToken()
+	if t != KeywordToken || Keywords[s] {
+		b.Error(tr, fmt.Sprintf("basic: error: expected a variable for %s", kw))
+		return VarExpr{}, false
+	}
+	return VarExpr{s}, true
+}
+
+// compileStringVar compiles a variable which must be a string variable; a variable without
+// a suffix is checked when it is compiled, once its type is known.
+func (b *Basic) compileStringVar(tr *TokenReader, kw string) (VarExpr, bool) {
+	t, _, s := tr.ReadToken()
+	if t != KeywordToken || Keywords[s] || (VarType(s) != NoType && VarType(s) != StringType) {
+		b.Error(tr, fmt.Sprintf("basic: error: expected a string variable for %s", kw))
+		return VarExpr{}, false
+	}
+	return VarExpr{s}, true
+}
+
+// compileAssign compiles the rest of an assignment to the variable name.
+func (b *Basic) compileAssign(tr *TokenReader, name string, let bool) (Stmt, bool) {
+	t, _, op := tr.ReadToken()
+	if t != OperatorToken || op != "=" {
+		b.Error(tr, "basic: error: expected '=' following variable name")
+		return nil, false
+	}
+	e, ok := b.CompileExpr(tr)
+	if !ok {
+		return nil, false
+	}
+	return AssignStmt{Var: name, Expr: e, Let: let}, true
+}
+
+// compileDef compiles the letter ranges of DEFINT, DEFSNG, DEFDBL, or DEFSTR.
+func (b *Basic) compileDef(tr *TokenReader, kw string, typ Type) (Stmt, bool) {
+	letter := func() (byte, bool) {
+		t, _, s := tr.ReadToken()
+		if t != KeywordToken || len(s) != 1 {
+			b.Error(tr, fmt.Sprintf("basic: error: expected a letter for %s", kw))
+			return 0, false
+		}
+		return s[0], true
+	}
+
+	ds := DefStmt{Type: typ}
+	for {
+		first, ok := letter()
+		if !ok {
+			return nil, false
+		}
+		last := first
+		if t, _, s := tr.PeekToken(); t == OperatorToken && s == "-" {
+			tr.ReadToken()
+			last, ok = letter()
+			if !ok {
+				return nil, false
+			}
+			if last < first {
+				b.Error(tr, fmt.Sprintf("basic: error: bad range of letters for %s", kw))
+				return nil, false
+			}
+		}
+		ds.Ranges = append(ds.Ranges, LetterRange{first, last})
+
+		if t, _, s := tr.PeekToken(); t != OperatorToken || s != "," {
+			break
+		}
+		tr.ReadToken()
+	}
+	return ds, true
+}
+
+// atEndOfStatement returns true if the next token ends a statement.
+func atEndOfStatement(tr *TokenReader) bool {
+	t, _, s := tr.PeekToken()
+	return t == EndOfLine || (t == KeywordToken && s == "ELSE")
 }
 
 func (b *Basic) CompileKeyword(tr *TokenReader, kw string, full bool) (Stmt, bool) {
 	var stmt Stmt
 
 	switch kw {
-	case "END":
-		stmt = EndStmt{}
+	case "END", "SYSTEM":
+		es := EndStmt{System: kw == "SYSTEM"}
+		if !atEndOfStatement(tr) {
+			var ok bool
+			es.Status, ok = b.CompileExpr(tr)
+			if !ok {
+				return nil, false
+			}
+		}
+		stmt = es
 
-	case "FOR":
-		// XXX
+	case "GOSUB":
+		t, n, _ := tr.ReadToken()
+		if t != IntegerToken {
+			b.Error(tr, "basic: error: missing line number for GOSUB")
+			return nil, false
+		}
+		stmt = GoSubStmt{Number: n}
 
-	case "NEXT":
-		// XXX
+	case "RETURN":
+		stmt = ReturnStmt{}
+
+	case "GOTO":
+		t, n, _ := tr.ReadToken()
+		if t != IntegerToken {
+			b.Error(tr, "basic: error: missing line number for GOTO")
+			return nil, false
+		}
+		stmt = GotoStmt{Number: n}
+
+	case "IF":
+		e, ok := b.CompileExpr(tr)
+		if !ok {
+			return nil, false
+		}
+		t, _, s := tr.ReadToken()
+		if t == KeywordToken && s == "THEN" {
+			then, ok := b.CompileStatement(tr, false)
+			if !ok {
+				return nil, false
+			}
+
+			var els Stmt
+			t, _, s := tr.PeekToken()
+			if t == KeywordToken && s == "ELSE" {
+				tr.ReadToken()
+				els, ok = b.CompileStatement(tr, false)
+				if !ok {
+					return nil, false
+				}
+			}
+			stmt = IfThenStmt{
+				Test: e,
+				Then: then,
+				Else: els,
+			}
+		} else if t == KeywordToken && s == "GOTO" {
+			t, n, _ := tr.ReadToken()
+			if t != IntegerToken {
+				b.Error(tr, "basic: error: missing line number for IF GOTO")
+				return nil, false
+			}
+			stmt = IfGotoStmt{
+				Test:   e,
+				Number: n,
+			}
+		} else {
+			b.Error(tr, "basic: error: expected IF followed by THEN or GOTO")
+			return nil, false
+		}
+
+	case "INPUT":
+		var is InputStmt
+		if t, _, s := tr.PeekToken(); t == OperatorToken && s == "#" {
+			f, ok := b.compileFileNumber(tr, kw, true)
+			if !ok {
+				return nil, false
+			}
+			is.File = f
+		} else if t == StringToken {
+			tr.ReadToken()
+			is.Prompt = &s
+			t, _, sep := tr.ReadToken()
+			if t != OperatorToken || (sep != ";" && sep != ",") {
+				b.Error(tr, "basic: error: expected ; or , following the prompt of INPUT")
+				return nil, false
+			}
+			is.Comma = sep == ","
+		}
+		for {
+			v, ok := b.compileInputVar(tr, kw)
+			if !ok {
+				return nil, false
+			}
+			is.Vars = append(is.Vars, v)
+			if t, _, s := tr.PeekToken(); t != OperatorToken || s != "," {
+				break
+			}
+			tr.ReadToken()
+		}
+		stmt = is
+
+	case "LINE":
+		if t, _, s := tr.ReadToken(); t != KeywordToken || s != "INPUT" {
+			b.Error(tr, "basic: error: expected INPUT following LINE")
+			return nil, false
+		}
+		var lis LineInputStmt
+		if t, _, s := tr.PeekToken(); t == OperatorToken && s == "#" {
+			f, ok := b.compileFileNumber(tr, "LINE INPUT", true)
+			if !ok {
+				return nil, false
+			}
+			lis.File = f
+		} else if t == StringToken {
+			tr.ReadToken()
+			lis.Prompt = &s
+			if t, _, s := tr.ReadToken(); t != OperatorToken || s != ";" {
+				b.Error(tr, "basic: error: expected ; following the prompt of LINE INPUT")
+				return nil, false
+			}
+		}
+		v, ok := b.compileStringVar(tr, "LINE INPUT")
+		if !ok {
+			return nil, false
+		}
+		lis.Var = v
+		stmt = lis
+
+	case "OPEN":
+		e, ok := b.CompileExpr(tr)
+		if !ok {
+			return nil, false
+		}
+		if t, _, s := tr.PeekToken(); t == KeywordToken && s == "FOR" {
+			tr.ReadToken()
+			t, _, mode := tr.ReadToken()
+			if t != KeywordToken || (mode != "INPUT" && mode != "OUTPUT" && mode != "APPEND") {
+				b.Error(tr, "basic: error: expected INPUT, OUTPUT, or APPEND following FOR")
+				return nil, false
+			}
+			if t, _, s := tr.ReadToken(); t != KeywordToken || s != "AS" {
+				b.Error(tr, "basic: error: expected AS following OPEN mode")
+				return nil, false
+			}
+			n, ok := b.compileFileNumber(tr, kw, false)
+			if !ok {
+				return nil, false
+			}
+			stmt = OpenStmt{
+				Mode:   ValueExpr{Value: StringValue(mode)},
+				Number: n,
+				Name:   e,
+				For:    true,
+			}
+		} else if t == OperatorToken && s == "," {
+			tr.ReadToken()
+			n, ok := b.compileFileNumber(tr, kw, true)
+			if !ok {
+				return nil, false
+			}
+			name, ok := b.CompileExpr(tr)
+			if !ok {
+				return nil, false
+			}
+			var length Expr
+			if t, _, s := tr.PeekToken(); t == OperatorToken && s == "," {
+				tr.ReadToken()
+				length, ok = b.CompileExpr(tr)
+				if !ok {
+					return nil, false
+				}
+			}
+			stmt = OpenStmt{
+				Mode:   e,
+				Number: n,
+				Name:   name,
+				Length: length,
+			}
+		} else {
+			b.Error(tr, "basic: error: expected , or FOR following OPEN")
+			return nil, false
+		}
+
+	case "CLOSE":
+		cs := CloseStmt{}
+		for !atEndOfStatement(tr) {
+			n, ok := b.compileFileNumber(tr, kw, false)
+			if !ok {
+				return nil, false
+			}
+			cs.Numbers = append(cs.Numbers, n)
+			if t, _, s := tr.PeekToken(); t != OperatorToken || s != "," {
+				break
+			}
+			tr.ReadToken()
+		}
+		stmt = cs
 
-	case "GOSUB":
-		t, n, _ := tr.ReadToken()
-		if t != IntegerToken {
-			b.Error(tr, "basic: error: missing line number for GOSUB")
+	case "KILL":
+		e, ok := b.CompileExpr(tr)
+		if !ok {
 			return nil, false
 		}
-		stmt = GoSubStmt(n)
-
-	case "RETURN":
-		stmt = ReturnStmt{}
+		stmt = KillStmt{e}
 
-	case "GOTO":
-		t, n, _ := tr.ReadToken()
-		if t != IntegerToken {
-			b.Error(tr, "basic: error: missing line number for GOTO")
+	case "ASSERT":
+		e, ok := b.CompileExpr(tr)
+		if !ok {
 			return nil, false
 		}
-		stmt = GotoStmt(n)
+		as := AssertStmt{Test: e}
+		if t, _, s := tr.PeekToken(); t == OperatorToken && s == "," {
+			tr.ReadToken()
+			as.Message, ok = b.CompileExpr(tr)
+			if !ok {
+				return nil, false
+			}
+		}
+		stmt = as
 
-	case "IF":
+	case "NAME":
+		old, ok := b.CompileExpr(tr)
+		if !ok {
+			return nil, false
+		}
+		if t, _, s := tr.ReadToken(); t != KeywordToken || s != "AS" {
+			b.Error(tr, "basic: error: expected AS following NAME")
+			return nil, false
+		}
 		e, ok := b.CompileExpr(tr)
 		if !ok {
 			return nil, false
 		}
-		t, _, s := tr.ReadToken()
-		if t == KeywordToken && s == "THEN" {
-			then, ok := b.CompileStatement(tr, false)
+		stmt = NameStmt{Old: old, New: e}
+
+	case "FILES":
+		var e Expr
+		if !atEndOfStatement(tr) {
+			var ok bool
+			e, ok = b.CompileExpr(tr)
 			if !ok {
 				return nil, false
 			}
+		}
+		stmt = FilesStmt{e}
 
-			var els Stmt
-			t, _, s := tr.PeekToken()
-			if t == KeywordToken && s == "ELSE" {
-				tr.ReadToken()
-				els, ok = b.CompileStatement(tr, false)
-				if !ok {
-					return nil, false
-				}
+	case "FIELD":
+		f, ok := b.compileFileNumber(tr, kw, true)
+		if !ok {
+			return nil, false
+		}
+		fs := FieldStmt{File: f}
+		for {
+			n, ok := b.CompileExpr(tr)
+			if !ok {
+				return nil, false
 			}
-			stmt = IfThenStmt{
-				Test: e,
-				Then: then,
-				Else: els,
+			if t, _, s := tr.ReadToken(); t != KeywordToken || s != "AS" {
+				b.Error(tr, "basic: error: expected AS following FIELD length")
+				return nil, false
 			}
-		} else if t == KeywordToken && s == "GOTO" {
-			t, n, _ := tr.ReadToken()
-			if t != IntegerToken {
-				b.Error(tr, "basic: error: missing line number for IF GOTO")
+			v, ok := b.compileStringVar(tr, kw)
+			if !ok {
 				return nil, false
 			}
-			stmt = IfGotoStmt{
-				Test:   e,
-				Number: n,
+			fs.Items = append(fs.Items, FieldItem{n, v})
+			if t, _, s := tr.PeekToken(); t != OperatorToken || s != "," {
+				break
 			}
-		} else {
-			b.Error(tr, "basic: error: expected IF followed by THEN or GOTO")
+			tr.ReadToken()
+		}
+		stmt = fs
+
+	case "GET", "PUT":
+		f, ok := b.compileFileNumber(tr, kw, false)
+		if !ok {
 			return nil, false
 		}
+		gps := GetPutStmt{Put: kw == "PUT", File: f}
+		if t, _, s := tr.PeekToken(); t == OperatorToken && s == "," {
+			tr.ReadToken()
+			gps.Record, ok = b.CompileExpr(tr)
+			if !ok {
+				return nil, false
+			}
+		}
+		stmt = gps
 
-	case "INPUT":
-		// XXX
+	case "LSET", "RSET":
+		v, ok := b.compileStringVar(tr, kw)
+		if !ok {
+			return nil, false
+		}
+		if t, _, s := tr.ReadToken(); t != OperatorToken || s != "=" {
+			b.Error(tr, fmt.Sprintf("basic: error: expected = following %s variable", kw))
+			return nil, false
+		}
+		e, ok := b.CompileExpr(tr)
+		if !ok {
+			return nil, false
+		}
+		stmt = SetStmt{Right: kw == "RSET", Var: v, Expr: e}
+
+	case "WRITE":
+		ws := WriteStmt{}
+		if t, _, s := tr.PeekToken(); t == OperatorToken && s == "#" {
+			f, ok := b.compileFileNumber(tr, kw, true)
+			if !ok {
+				return nil, false
+			}
+			ws.File = f
+		}
+		for !atEndOfStatement(tr) {
+			e, ok := b.CompileExpr(tr)
+			if !ok {
+				return nil, false
+			}
+			ws.Exprs = append(ws.Exprs, e)
+			if t, _, s := tr.PeekToken(); t != OperatorToken || s != "," {
+				break
+			}
+			tr.ReadToken()
+		}
+		stmt = ws
 
 	case "PRINT":
 		ps := PrintStmt{}
-		for {
+		t, _, s := tr.PeekToken()
+		if t == OperatorToken && s == "#" {
+			f, ok := b.compileFileNumber(tr, kw, true)
+			if !ok {
+				return nil, false
+			}
+			ps.File = f
+			t, _, s = tr.PeekToken()
+		}
+		if t == KeywordToken && s == "USING" {
+			tr.ReadToken()
 			e, ok := b.CompileExpr(tr)
 			if !ok {
 				return nil, false
 			}
-			ps.exprs = append(ps.exprs, e)
+			t, _, s = tr.ReadToken()
+			if t != OperatorToken || s != ";" {
+				b.Error(tr, "basic: error: expected ; following PRINT USING format")
+				return nil, false
+			}
+			ps.Using = e
+		}
 
+		for !atEndOfStatement(tr) {
 			t, _, s := tr.PeekToken()
-			if t != OperatorToken || s != "," {
-				break
+			if t == OperatorToken && s == "," {
+				tr.ReadToken()
+				ps.Items = append(ps.Items, PrintItem{Kind: PrintComma})
+			} else if t == OperatorToken && s == ";" {
+				tr.ReadToken()
+				ps.Items = append(ps.Items, PrintItem{Kind: PrintSemicolon})
+			} else if t == KeywordToken && (s == "TAB" || s == "SPC") && ps.Using == nil {
+				tr.ReadToken()
+				t, _, p := tr.ReadToken()
+				if t != OperatorToken || p != "(" {
+					b.Error(tr, fmt.Sprintf("basic: error: expected ( following %s", s))
+					return nil, false
+				}
+				e, ok := b.CompileExpr(tr)
+				if !ok {
+					return nil, false
+				}
+				t, _, p = tr.ReadToken()
+				if t != OperatorToken || p != ")" {
+					b.Error(tr, fmt.Sprintf("basic: error: missing closing ) for %s", s))
+					return nil, false
+				}
+				kind := PrintTab
+				if s == "SPC" {
+					kind = PrintSpc
+				}
+				ps.Items = append(ps.Items, PrintItem{Kind: kind, Expr: e})
+			} else {
+				e, ok := b.CompileExpr(tr)
+				if !ok {
+					return nil, false
+				}
+				ps.Items = append(ps.Items, PrintItem{Kind: PrintExpr, Expr: e})
 			}
-			tr.ReadToken()
 		}
 		stmt = ps
 
+	case "WIDTH":
+		e, ok := b.CompileExpr(tr)
+		if !ok {
+			return nil, false
+		}
+		stmt = WidthStmt{e}
+
 	case "REM":
 		var s string
 		for {
-			ch := tr.ReadRune()
+			ch := tr.ReadChar()
 			if ch == '\n' {
-				tr.UnreadRune()
+				tr.UnreadChar()
 				break
 			}
 			s += string(ch)
 		}
 		stmt = RemStmt(s)
 
-	case "WHILE":
+	case "FOR", "NEXT", "WHILE", "WEND":
 		// XXX
+		b.Error(tr, "basic: error: Syntax error")
+		return nil, false
 
-	case "WEND":
-		// XXX
+	case "LET":
+		t, _, s := tr.ReadToken()
+		if t != KeywordToken || Keywords[s] {
+			b.Error(tr, "basic: error: expected a variable following LET")
+			return nil, false
+		}
+		var ok bool
+		stmt, ok = b.compileAssign(tr, s, true)
+		if !ok {
+			return nil, false
+		}
+
+	case "DEFINT", "DEFSNG", "DEFDBL", "DEFSTR":
+		typ := IntegerType
+		switch kw {
+		case "DEFSNG":
+			typ = SingleType
+		case "DEFDBL":
+			typ = DoubleType
+		case "DEFSTR":
+			typ = StringType
+		}
+		var ok bool
+		stmt, ok = b.compileDef(tr, kw, typ)
+		if !ok {
+			return nil, false
+		}
 
 	default:
-		if kw[len(kw)-1] == '$' || kw[len(kw)-1] == '%' {
-			t, _, op := tr.ReadToken()
-			if t != OperatorToken || op != "=" {
-				b.Error(tr, "basic: error: expected '=' following variable name")
-				return nil, false
-			}
-			e, ok := b.CompileExpr(tr)
-			if !ok {
-				return nil, false
-			}
-			stmt = AssignStmt{kw, e}
-		} else {
-			b.Error(tr, fmt.Sprintf("basic: error: unknown keyword: %s", kw))
+		if t, _, s := tr.PeekToken(); Keywords[kw] || t != OperatorToken || s != "=" {
+			b.Error(tr, "basic: error: Syntax error")
+			return nil, false
+		}
+		var ok bool
+		stmt, ok = b.compileAssign(tr, kw, false)
+		if !ok {
 			return nil, false
 		}
 	}
 
 	if full {
-		t, _, _ := tr.ReadToken()
+		t, _, s := tr.ReadToken()
 		if t != EndOfLine {
 			b.Error(tr, fmt.Sprintf("basic: error: too many argument to keyword: %s", kw))
 			return nil, false
 		}
+		if s != "" {
+			stmt = CommentStmt{stmt, s}
+		}
 	}
 	return stmt, true
 }
 
 func (b *Basic) CompileStatement(tr *TokenReader, full bool) (Stmt, bool) {
 	t, _, kw := tr.ReadToken()
+	if t == EndOfLine && full && kw != "" {
+		return CommentStmt{Comment: kw}, true
+	}
 	if t != KeywordToken {
 		b.Error(tr, "basic: error: statement must start with a keyword or variable")
 		return nil, false
@@ -898,26 +2131,29 @@ func (l Line) Less(than btree.Item) bool {
 	return l.Number < (than.(Line)).Number
 }
 
-func (b *Basic) Run() {
-	var ln int
-	var stk []Ctx
-
-	for {
-		var line Line
-		b.Code.AscendGreaterOrEqual(Line{ln, nil},
-			func(item btree.Item) bool {
-				line = item.(Line)
-				return false
-			})
-		if line.Number == 0 {
-			break
-		}
+// Run runs the program; all files are closed before it starts and after it ends. It returns
+// the exit status of the program: the status given by END or SYSTEM, 0 if there wasn't one,
+// ExitLoadError if it can't be compiled, ExitRuntimeError if it stops with an error, or
+// ExitBreak if it is interrupted.
+func (b *Basic) Run() int {
+	b.CloseFiles()
+	img, ok := b.Compile()
+	if !ok {
+		return ExitLoadError
+	}
+	return b.RunImage(img)
+}
 
-		ln, stk = line.Stmt.Execute(b, line.Number, stk)
-		if ln <= 0 {
-			break
-		}
+// RunImage runs img, which may have been read from a bytecode file, the same as Run.
+func (b *Basic) RunImage(img *Image) int {
+	b.CloseFiles()
+	b.status = 0
+	ok := b.Execute(img)
+	b.CloseFiles()
+	if !ok && b.status != ExitBreak {
+		b.status = ExitRuntimeError
 	}
+	return b.status
 }
 
 func readRange(tr *TokenReader, opt bool) (int, int, bool) {
@@ -956,27 +2192,49 @@ func readRange(tr *TokenReader, opt bool) (int, int, bool) {
 	return strt, end, true
 }
 
+// commands are the commands which are not keywords, so that programs can use them as the
+// names of variables.
+var commands = map[string]bool{
+	"GRAPH":   true,
+	"LINT":    true,
+	"PROFILE": true,
+	"XREF":    true,
+}
+
 func (b *Basic) Program(tr *TokenReader) {
+	defer b.CloseFiles()
+
 	for {
-		for {
-			ch, eof := tr.ReadRuneEOF()
-			if eof {
-				return
+		if !tr.SkipBlankLines() {
+			if tr.Err != nil {
+				fmt.Fprintf(b.ErrW, "basic: error: %s\n", tr.Err)
 			}
-			if ch != ' ' && ch != '\n' {
-				break
+			return
+		}
+
+		t, n, s := KeywordToken, 0, ""
+		cmd, ok := tr.ReadCommand(commands)
+		if ok {
+			s = cmd
+			if pt, _, ps := tr.PeekToken(); pt == OperatorToken && ps == "=" {
+				// The command is being assigned to as a variable, as in LINT = 1.
+				cmd = ""
 			}
+		} else {
+			t, n, s = tr.ReadToken()
 		}
-		tr.UnreadRune()
 
-		t, n, s := tr.ReadToken()
 		if t == IntegerToken {
 			stmt, ok := b.CompileStatement(tr, true)
-			if ok {
+			if ok && b.CheckLine(n, stmt) {
 				b.Code.ReplaceOrInsert(Line{n, stmt})
 			}
 		} else if t == KeywordToken {
-			switch s {
+			kw := s
+			if commands[s] && cmd == "" {
+				kw = ""
+			}
+			switch kw {
 			case "DELETE":
 				strt, end, ok := readRange(tr, false)
 				if !ok {
@@ -1029,26 +2287,63 @@ func (b *Basic) Program(tr *TokenReader) {
     | <line-number> <statement>
     | DELETE <line-number> [ '-' <line-number> ] ; delete one or a range of line numbers inclusive
     | EXIT
+    | GRAPH <filename> ; write the control-flow graph of the program to <filename> for Graphviz
     | HELP
     | LIST [ <line-number> [ '-' <line-number> ]]
+    | LINT ; report missing lines, unreachable lines, subroutines without RETURN, and
+           ; variables which are used but never assigned
     | LOAD <filename> ; load a program into memory from <filename>
+    | MERGE <filename> ; add the lines of <filename> to the program in memory
     | NEW ; start over with a new program
+    | PROFILE ( ON | OFF ) ; count the runs of each line and time them; OFF lists the lines
+                          ; which took the most time first
     | RUN ; run the program from the beginning
     | SAVE <filename> ; save the program in memory to <filename>
+    | XREF [ JSON ] ; list the lines which jump to each line, and the lines which assign and
+                    ; read each variable
 
 <statement> =
-    | END ; end execution of the program
+    | ASSERT <logical-expr> [ ',' <string-expr> ] ; stop with an error if <logical-expr> is false
+    | CLOSE [ [ '#' ] <file-number> [ ',' ... ]] ; close the files, or all files
+    | ( DEFINT | DEFSNG | DEFDBL | DEFSTR ) <letter> [ '-' <letter> ] [ ',' ... ]
+                                          ; type of variables without a suffix by first letter
+    | END [ <integer-expr> ] ; end execution of the program, with an exit status from 0 to 255
+    | FIELD [ '#' ] <file-number> ',' <integer-expr> AS <string-variable> [ ',' ... ]
+                                          ; alias variables to parts of the record buffer
+    | FILES [ <string-expr> ] ; list the files matching a pattern with '*' and '?' wildcards
     | <for>
     | GOSUB <line-number> ... RETURN
+    | GET [ '#' ] <file-number> [ ',' <integer-expr> ] ; read a record of a random file
     | GOTO <line-number>
     | IF <logical-expr> THEN <statement> [ELSE <statement>]
     | IF <logical-expr> GOTO <line-number>
-    | INPUT [ <string> ',' ] <variable>
-    | <string-variable> '=' <string-expr>
-    | <integer-variable> '=' <integer-expr>
-    | PRINT <expr> [ ','  ...]
+    | INPUT [ <string> ( ';' | ',' ) ] <variable> [ ',' ... ] ; read a line of items from the
+                                          ; keyboard; ';' after the prompt adds a '?'
+    | INPUT '#' <file-number> ',' <variable> [ ',' ... ] ; read items from a file
+    | KILL <string-expr> ; delete a file
+    | LINE INPUT [ <string> ';' ] <string-variable> ; read a line from the keyboard
+    | LINE INPUT '#' <file-number> ',' <string-variable> ; read a line from a file
+    | ( LSET | RSET ) <string-variable> '=' <string-expr> ; left or right justify in a field
+    | NAME <string-expr> AS <string-expr> ; rename a file
+    | OPEN <mode> ',' [ '#' ] <file-number> ',' <filename> [ ',' <integer-expr> ]
+                                          ; <mode> is "I", "O", "A", or "R" with a record length
+    | OPEN <filename> FOR ( INPUT | OUTPUT | APPEND ) AS [ '#' ] <file-number>
+    | [ LET ] <variable> '=' <expr> ; numbers are converted to the type of the variable
+    | PRINT [ <print-item> | ',' | ';' ] ... ; ',' moves to the next zone; a trailing ',' or ';'
+                                          ; suppresses the newline
+    | PRINT USING <string-expr> ';' <expr> [ ( ',' | ';' ) ... ] ; formatted output
+    | PRINT '#' <file-number> ',' ... ; PRINT or PRINT USING to a file
+    | '?' ... ; same as PRINT
+    | PUT [ '#' ] <file-number> [ ',' <integer-expr> ] ; write a record of a random file
     | REM ... ; comment (remark); ' at the end of the line is also a comment
+    | SYSTEM [ <integer-expr> ] ; end execution of the program and leave BASIC
     | <while>
+    | WIDTH <integer-expr> ; set the width of output lines; 255 turns off wrapping
+    | WRITE [ '#' <file-number> ',' ] <expr> [ ',' ... ] ; output comma separated values
+
+<file-number> = <integer-expr> ; 1 to 15
+
+<print-item> = <expr> | TAB '(' <integer-expr> ')' | SPC '(' <integer-expr> ')'
 
 <for> = ; execute the statements with <variable> going from <start> to <end> inclusively
     FOR <variable> = <start> TO <end> [ STEP <step> ]
@@ -1061,9 +2356,12 @@ func (b *Basic) Program(tr *TokenReader) {
     WEND
 
 <string> = '"' ... '"'
-<integer> = [ '-' ] <digit> ...
+<integer> = [ '-' ] <digit> ... [ '%' ] | '&H' <hex-digit> ... | '&O' <octal-digit> ...
+<number> = <digit> ... [ '.' <digit> ... ] [ ( E | D ) [ '+' | '-' ] <digit> ... ] [ '!' | '#' ]
+                                          ; D or '#' is double precision
 <digit> = '0' ... '9'
-<variable> = <string-variable> | <integer-variable>
+<variable> = <name> [ '$' | '%' | '!' | '#' ] ; A, A$, A%, A!, and A# are different variables,
+                                          ; except that A is A! unless changed by DEFINT etc.
 <string-variable> = <name> '$'
 <integer-variable> = <name> '%'
 <line> = <line-number> <statement>
@@ -1071,8 +2369,17 @@ func (b *Basic) Program(tr *TokenReader) {
       <integer-variable>
     | <integer>
     | '-' <expr>
-    | <integer-expr> ( '+' | '-' | '*' | '/' ) <integer-expr>
+    | <integer-expr> ( '+' | '-' | '*' | '/' | '\' ) <integer-expr> ; '/' gives a single precision result
     | <intrinsic> '(' <expr> ... ')'
+<intrinsic> =
+      EOF '(' <file-number> ')' ; true if there is no more input, or if the last GET was past
+                                ; the end of a random file
+    | LOC '(' <file-number> ')' ; number of 128 byte records read or written, or the last
+                                ; record of a random file
+    | LOF '(' <file-number> ')' ; length of the file in bytes
+    | ( MKI$ | MKS$ | MKD$ ) '(' <expr> ')' ; number to a string of 2, 4, or 8 bytes
+    | ( CVI | CVS | CVD ) '(' <string-expr> ')' ; string of 2, 4, or 8 bytes to a number
+    | CHR$ '(' <expr> ')' ; string of one character with the code 0 to 255
 <logical-expr> =
       <integer-expr> <logical-op> <integer-expr>
     | <string-expr> <logical-op> <string-expr>
@@ -1102,6 +2409,39 @@ func (b *Basic) Program(tr *TokenReader) {
 						return true
 					})
 
+			case "GRAPH":
+				t, _, s = tr.ReadToken()
+				if t != StringToken {
+					b.Error(tr, "basic: error: GRAPH expects one string argument")
+					break
+				}
+				t, _, _ = tr.ReadToken()
+				if t != EndOfLine {
+					b.Error(tr, "basic: error: GRAPH expects one string argument")
+					break
+				}
+				b.SaveGraph(s)
+
+			case "LINT":
+				t, _, _ = tr.ReadToken()
+				if t != EndOfLine {
+					b.Error(tr, "basic: error: LINT takes no arguments")
+					break
+				}
+				b.Lint(b.W, "-")
+
+			case "XREF":
+				t, _, s = tr.ReadToken()
+				json := t == KeywordToken && s == "JSON"
+				if json {
+					t, _, _ = tr.ReadToken()
+				}
+				if t != EndOfLine {
+					b.Error(tr, "basic: error: XREF expects JSON or no arguments")
+					break
+				}
+				b.WriteXref(b.W, json)
+
 			case "LOAD":
 				t, _, s = tr.ReadToken()
 				if t != StringToken {
@@ -1115,6 +2455,19 @@ func (b *Basic) Program(tr *TokenReader) {
 				}
 				b.Load(s)
 
+			case "MERGE":
+				t, _, s = tr.ReadToken()
+				if t != StringToken {
+					b.Error(tr, "basic: error: MERGE expects one string argument")
+					break
+				}
+				t, _, _ = tr.ReadToken()
+				if t != EndOfLine {
+					b.Error(tr, "basic: error: MERGE expects one string argument")
+					break
+				}
+				b.Merge(s)
+
 			case "NEW":
 				t, _, _ = tr.ReadToken()
 				if t != EndOfLine {
@@ -1123,6 +2476,25 @@ func (b *Basic) Program(tr *TokenReader) {
 				}
 				b.New()
 
+			case "PROFILE":
+				t, _, s = tr.ReadToken()
+				on := t == KeywordToken && s == "ON"
+				if t != KeywordToken || (s != "ON" && s != "OFF") {
+					b.Error(tr, "basic: error: PROFILE expects ON or OFF")
+					break
+				}
+				t, _, _ = tr.ReadToken()
+				if t != EndOfLine {
+					b.Error(tr, "basic: error: PROFILE expects ON or OFF")
+					break
+				}
+				if on {
+					b.Profile = NewProfile()
+				} else if b.Profile != nil {
+					b.WriteProfile(b.W, b.Profile)
+					b.Profile = nil
+				}
+
 			case "RUN":
 				t, _, _ = tr.ReadToken()
 				if t != EndOfLine {
@@ -1130,6 +2502,9 @@ func (b *Basic) Program(tr *TokenReader) {
 					break
 				}
 				b.Run()
+				if b.system {
+					return
+				}
 
 			case "SAVE":
 				t, _, s = tr.ReadToken()
@@ -1147,7 +2522,13 @@ func (b *Basic) Program(tr *TokenReader) {
 			default:
 				stmt, ok := b.CompileKeyword(tr, s, true)
 				if ok {
-					stmt.Execute(b, 0, nil)
+					c := NewCompiler(b)
+					if stmt.Compile(c) {
+						b.Execute(c.Image())
+						if b.system {
+							return
+						}
+					}
 				}
 			}
 		} else {
@@ -1156,19 +2537,167 @@ func (b *Basic) Program(tr *TokenReader) {
 	}
 }
 
+// interruptOnSignal interrupts the program running in b, instead of exiting, when the process
+// gets SIGINT, as from Control-C.
+func interruptOnSignal(b *Basic) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	go func() {
+		for range ch {
+			b.Interrupt()
+		}
+	}()
+}
+
 func main() {
-	if len(os.Args) == 1 {
+	compile := flag.String("c", "", "compile `program` to bytecode")
+	output := flag.String("o", "", "write bytecode to `file` (default: program with .bbc extension)")
+	mbf := flag.Bool("mbf", false, "emulate Microsoft Binary Format floating point arithmetic")
+	crunched := flag.Bool("crunched", false,
+		"allow keywords without spaces around them, as in IFA>5THENPRINTA+B")
+	json := flag.Bool("json", false, "write the cross-reference from xref as JSON")
+	profile := flag.String("profile", "",
+		"count and time the lines of program as it runs, and write a report to `file`")
+	pprof := flag.Bool("pprof", false, "write the -profile report in the format of go tool pprof")
+	update := flag.Bool("update", false,
+		"write the .out files of conform, instead of comparing with them")
+	cover := flag.Bool("cover", false,
+		"record which lines of program run, adding to the coverage of earlier runs, and write "+
+			"a listing of them to program with .cover extension")
+	coverProfile := flag.String("coverprofile", "",
+		"read and write the coverage as an LCOV tracefile `file` (default: program with .lcov "+
+			"extension)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"usage: basic [-mbf] [-crunched] [-profile file [-pprof]] [program | bytecode]\n"+
+				"       basic [-mbf] [-crunched] -cover [-coverprofile file] program\n"+
+				"       basic [-crunched] -c program [-o file]\n"+
+				"       basic [-crunched] lint program\n"+
+				"       basic [-crunched] [-json] xref program\n"+
+				"       basic [-crunched] graph program\n"+
+				"       basic [-mbf] [-crunched] test [directory | test-program] ...\n"+
+				"       basic [-update] conform directory\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"The exit status of running a program is the status given by END or SYSTEM, %d if\n"+
+				"it can't be loaded, %d if it stops with an error, or %d if it is interrupted.\n"+
+				"Compiling with -c exits with 0, or %[1]d if the program can't be loaded.\n",
+			ExitLoadError, ExitRuntimeError, ExitBreak)
+	}
+	flag.Parse()
+
+	if *compile != "" {
+		if flag.NArg() != 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		fn := *output
+		if fn == "" {
+			fn = strings.TrimSuffix(*compile, filepath.Ext(*compile)) + ".bbc"
+		}
+
+		b := NewBasic(os.Stdout, os.Stderr)
+		b.Crunched = *crunched
+		if !b.Load(*compile) {
+			os.Exit(ExitLoadError)
+		}
+		img, ok := b.Compile()
+		if !ok || !b.SaveImage(fn, img) {
+			os.Exit(ExitLoadError)
+		}
+		os.Exit(0)
+	} else if flag.NArg() == 0 {
 		fmt.Print(`BASIC
 type help for help and exit to exit
 `)
-		NewBasic(os.Stdout, os.Stderr).Program(
-			&TokenReader{
-				R: bufio.NewReader(os.Stdin),
-			})
-	} else {
 		b := NewBasic(os.Stdout, os.Stderr)
-		if b.Load(os.Args[1]) {
-			b.Run()
+		b.MBF = *mbf
+		b.Crunched = *crunched
+		tr := &TokenReader{
+			R:        bufio.NewReader(os.Stdin),
+			Crunched: *crunched,
+		}
+		b.In = tr.R
+		interruptOnSignal(b)
+		b.Program(tr)
+		if b.system {
+			os.Exit(b.status)
+		}
+	} else if flag.NArg() >= 1 && flag.Arg(0) == "test" {
+		b := NewBasic(os.Stdout, os.Stderr)
+		b.MBF = *mbf
+		b.Crunched = *crunched
+		files, err := TestFiles(b.FS, flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "basic: error: %s\n", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Println("no test files")
+		} else if _, failed := b.RunTests(os.Stdout, files); failed > 0 {
+			os.Exit(1)
+		}
+	} else if flag.NArg() == 2 && flag.Arg(0) == "conform" {
+		if _, failed := Conform(os.Stdout, OSFS{Root: flag.Arg(1)}, *update); failed > 0 {
+			os.Exit(1)
+		}
+	} else if flag.NArg() == 2 && flag.Arg(0) == "lint" {
+		b := NewBasic(os.Stdout, os.Stderr)
+		b.Crunched = *crunched
+		if !b.Load(flag.Arg(1)) || b.Lint(os.Stdout, flag.Arg(1)) != 0 {
+			os.Exit(1)
+		}
+	} else if flag.NArg() == 2 && flag.Arg(0) == "graph" {
+		b := NewBasic(os.Stdout, os.Stderr)
+		b.Crunched = *crunched
+		if !b.Load(flag.Arg(1)) {
+			os.Exit(1)
+		}
+		b.Graph(os.Stdout)
+	} else if flag.NArg() == 2 && flag.Arg(0) == "xref" {
+		b := NewBasic(os.Stdout, os.Stderr)
+		b.Crunched = *crunched
+		if !b.Load(flag.Arg(1)) {
+			os.Exit(1)
+		}
+		b.WriteXref(os.Stdout, *json)
+	} else if flag.NArg() == 1 {
+		b := NewBasic(os.Stdout, os.Stderr)
+		b.MBF = *mbf
+		b.Crunched = *crunched
+		b.In = bufio.NewReader(os.Stdin)
+		if *profile != "" || *cover {
+			b.Profile = NewProfile()
 		}
+		interruptOnSignal(b)
+		status := ExitLoadError
+		if IsImageFile(b.FS, flag.Arg(0)) {
+			if *cover {
+				fmt.Fprintln(b.ErrW, "basic: error: -cover needs the program, not bytecode")
+				os.Exit(1)
+			}
+			if img, ok := b.LoadImage(flag.Arg(0)); ok {
+				status = b.RunImage(img)
+			}
+		} else if b.Load(flag.Arg(0)) {
+			status = b.Run()
+			if *cover {
+				base := strings.TrimSuffix(flag.Arg(0), filepath.Ext(flag.Arg(0)))
+				lcov := *coverProfile
+				if lcov == "" {
+					lcov = base + ".lcov"
+				}
+				if !b.SaveCoverage(flag.Arg(0), lcov, base+".cover") {
+					os.Exit(1)
+				}
+			}
+		}
+		if *profile != "" && !b.SaveProfile(*profile, *pprof, flag.Arg(0)) {
+			os.Exit(1)
+		}
+		os.Exit(status)
+	} else {
+		flag.Usage()
+		os.Exit(2)
 	}
 }
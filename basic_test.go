@@ -3,28 +3,54 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"io/fs"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestBasic(t *testing.T) {
 	cases := []struct {
 		in, out string
 	}{
-		{"print 123\n", "123\n"},
+		{"print 123\n", " 123 \n"},
 		{"print \"def\"\n", "def\n"},
 
-		{"print - 123\n", "-123\n"},
-		{"print - \"abc\"\n", "basic: error: expected an integer value\n"},
+		{"print - 123\n", "-123 \n"},
+		{"print - \"abc\"\n", "basic: error: Type mismatch\n"},
 
-		{"print 123 + 456\n", "579\n"},
+		{"print 123 + 456\n", " 579 \n"},
 		{"print \"abc\" + \"def\"\n", "abcdef\n"},
-		{"print 123 - 456\n", "-333\n"},
-		{"print \"abc\" - \"def\"\n", "basic: error: - does not work for strings\n"},
-		{"print 123 * 456\n", "56088\n"},
-		{"print 1234 / 56\n", "22\n"},
+		{"print 123 - 456\n", "-333 \n"},
+		{"print \"abc\" - \"def\"\n", "basic: error: Type mismatch\n"},
+		{"print 123 * 45\n", " 5535 \n"},
+		{"print 1234 / 56\n", " 22.03572 \n"},
+		{"print 1234 \\ 56, 7.6 \\ 2\n", " 22            4 \n"},
+		{"print 32767 + 1\n", "basic: error: Overflow\n"},
+		{"xyz% = - 32767\nxyz% = xyz% - 1\nprint xyz%\nxyz% = xyz% - 1\n",
+			"-32768 \nbasic: error: Overflow\n"},
+		{"print 200 * 200\n", "basic: error: Overflow\n"},
+		{"print 40000 + 1, 40000 * 40000\n", " 40001         1.6E+09 \n"},
+		{"print 40000 * 40000 * 40000 * 40000 * 40000 * 40000 * 40000 * 40000 * 40000\n",
+			"basic: error: Overflow\n"},
+		{"xyz% = 32767.4\nprint xyz%\n", " 32767 \n"},
+		{"xyz% = 32767.5\n", "basic: error: Overflow\n"},
+		{"xyz% = - 32768\n", ""},
+		{"xyz% = 40000 - 1\n", "basic: error: Overflow\n"},
 
-		{"print 12 + 34 * 56\n", "1916\n"},
-		{"print (12 + 34) * 56\n", "2576\n"},
+		{"print 1.5 + 2\n", " 3.5 \n"},
+		{"print 1.25 * 4, 10 / 4, - 2.5\n", " 5             2.5          -2.5 \n"},
+		{"print 1.123456789 + 1\n", " 2.123456789 \n"},
+		{"print 1.5 = 1.5, 3 < 2.5\n", "TRUE          FALSE\n"},
+		{"print 1.5 + \"abc\"\n", "basic: error: Type mismatch\n"},
+		{"print 1 / 0\n", "basic: error: Division by zero\n"},
+		{"print 1 \\ 0\n", "basic: error: Division by zero\n"},
+		{"print 1E70\n", "basic: error: Overflow\n"},
+		{"print 1D400\n", "basic: error: Overflow\n"},
+		{"print 1E-70\n", " 0 \n"},
+
+		{"print 12 + 34 * 56\n", " 1916 \n"},
+		{"print (12 + 34) * 56\n", " 2576 \n"},
 
 		{"print 123 = 456\n", "FALSE\n"},
 		{"print 123 = 123\n", "TRUE\n"},
@@ -32,14 +58,91 @@ func TestBasic(t *testing.T) {
 		{"print \"abc\" = \"abc\"\n", "TRUE\n"},
 
 		{"abc$ = \"def\"\n", ""},
-		{"abc$ = 123\n", "basic: error: expected a string value\n"},
+		{"abc$ = 123\n", "basic: error: Type mismatch\n"},
 		{"xyz% = 123\n", ""},
-		{"xyz% = \"def\"\n", "basic: error: expected an integer value\n"},
-		{"abc = 123\n", "basic: error: unknown keyword: ABC\n"},
+		{"xyz% = \"def\"\n", "basic: error: Type mismatch\n"},
+		{"xyz% = 2.5\nprint xyz%\n", " 3 \n"},
+		{"abc = 123\nprint abc\n", " 123 \n"},
+		{"abc 123\n", "basic: error: Syntax error\n"},
+		{"frobnicate\n", "basic: error: Syntax error\n"},
+		{"to = 1\n", "basic: error: Syntax error\n"},
+		{"print 1 + then\n", "basic: error: Syntax error\n"},
+		{"let\n", "basic: error: expected a variable following LET\n"},
+		{"let x 1\n", "basic: error: expected '=' following variable name\n"},
+		{"x = \"abc\"\n", "basic: error: Type mismatch\n"},
+		{"defint 1\n", "basic: error: expected a letter for DEFINT\n"},
+		{"defstr z-a\n", "basic: error: bad range of letters for DEFSTR\n"},
+		{`
+10 a = 1.5
+20 a% = 2
+30 a$ = "three"
+40 a# = 4.25#
+50 let a! = a + 1
+60 print a; a%; a$; a#; a!
+70 b = 1 / 3
+80 b# = 1# / 3
+90 print b; b#
+run
+`, " 2.5  2 three 4.25  2.5 \n .3333333  .3333333333333333 \n"},
+		{`
+10 defint i-n
+20 defstr s
+30 defdbl d, x - y
+40 i = 2.7
+50 s = "str"
+60 d = 1# / 3
+70 z = 1 / 3
+80 print i; s; d; z; i%; s$
+90 x = 1 / 3
+100 print x
+run
+print i; d
+list 10-30
+`, ` 3 str .3333333333333333  .3333333  3 str
+ .3333333432674408 
+ 3  .3333333333333333 
+10 DEFINT I-N
+20 DEFSTR S
+30 DEFDBL D, X-Y
+`},
+		{`
+10 defint a
+20 a = "x"
+30 line input #1, a
+run
+`, "basic: error: 20: Type mismatch\nbasic: error: 30: Type mismatch\n"},
+		{`
+10 defint a
+30 line input #1, a
+run
+`, "basic: error: 30: Type mismatch\n"},
+		{`
+10 if "a" + 1 goto 10
+20 defstr s
+30 s = "ok"
+40 print s
+5 s = 1
+run
+`, "basic: error: 10: Type mismatch\nok\n"},
+		{`
+10 s = 1
+5 defstr s
+run
+`, "basic: error: 10: Type mismatch\n"},
+		{"10 FOR I=1 TO 10\nlist\n", "basic: error: Syntax error\n"},
+		{"10 FOR\nlist\n", "basic: error: Syntax error\n"},
+		{"10 WEND\nlist\n", "basic: error: Syntax error\n"},
+		{"10 goto 20\nlint\n", "-:10: line 10: GOTO 20: line 20 does not exist\n"},
+		{"lint 10\n", "basic: error: LINT takes no arguments\n"},
+		{"10 goto 10\nxref\n", "LINE  JUMPED TO FROM\n10    10\n\nVARIABLE  ASSIGNED  READ\n"},
+		{"xref text\n", "basic: error: XREF expects JSON or no arguments\n"},
+		{"graph\n", "basic: error: GRAPH expects one string argument\n"},
+		{"profile\n", "basic: error: PROFILE expects ON or OFF\n"},
+		{"profile on\nprofile off\n", "  LINE      COUNT      SECONDS       %  STATEMENT\n"},
 		{"rem this is a comment\n", ""},
-		{"print 123\n", "123\n"},
+		{"print 123\n", " 123 \n"},
 		{"print \"def\"\n", "def\n"},
-		{"abc% = 123\nprint abc%\n", "123\n"},
+		{"abc% = 123\nprint abc%\n", " 123 \n"},
 		{"abc$ = \"def\"\nprint abc$\n", "def\n"},
 		{`
 abc% = 123
@@ -47,7 +150,7 @@ abc$ = "def"
 print abc%
 print abc$
 print abc%, abc$
-`, "123\ndef\n123, def\n"},
+`, " 123 \ndef\n 123          def\n"},
 		{`
 10 abc% = 123
 20 abc$ = "def"
@@ -55,7 +158,7 @@ print abc%, abc$
 40 print abc$
 50 print abc%, abc$
 run
-`, "123\ndef\n123, def\n"},
+`, " 123 \ndef\n 123          def\n"},
 		{`
 10 abc% = 123
 20 abc$ = "def"
@@ -64,7 +167,7 @@ run
 40 print abc$
 50 print abc%, abc$
 run
-`, "123, def\n"},
+`, " 123          def\n"},
 		{`
 10 abc% = 123
 20 abc$ = "def"
@@ -76,7 +179,7 @@ run
 55 return
 60 print "never ran"
 run
-`, "123, def\n123\ndef\n"},
+`, " 123          def\n 123 \ndef\n"},
 		{`
 10 abc% = 123
 20 abc$ = "def"
@@ -119,7 +222,7 @@ list 20 - 40
 40 print abc$
 50 print abc%, abc$
 run
-`, "123, def\n"},
+`, " 123          def\n"},
 		{`
 10 abc% = 123
 20 abc$ = "def"
@@ -128,25 +231,25 @@ run
 40 print abc$
 50 print abc%, abc$
 run
-`, "123\ndef\n123, def\n"},
+`, " 123 \ndef\n 123          def\n"},
 		{`
 10 abc% = 123
 20 if abc% = 123 then print 234
 30 print 456
 run
-`, "234\n456\n"},
+`, " 234 \n 456 \n"},
 		{`
 10 abc% = 123
 20 if abc% <> 123 then print 234
 30 print 456
 run
-`, "456\n"},
+`, " 456 \n"},
 		{`
 10 abc% = 123
 20 if abc% <> 123 then print 234 else print 789
 30 print 456
 run
-`, "789\n456\n"},
+`, " 789 \n 456 \n"},
 		{`
 10 abc% = 123
 20 if abc% = 123 then goto 40 else goto 60
@@ -155,7 +258,7 @@ run
 50 end
 60 print 345
 run
-`, "234\n"},
+`, " 234 \n"},
 		{`
 10 abc% = 123
 20 if abc% <> 123 then goto 40 else goto 60
@@ -164,14 +267,263 @@ run
 50 end
 60 print 345
 run
-`, "345\n"},
+`, " 345 \n"},
+		{`
+10 print 234
+20 print abc%
+run
+`, " 234 \nbasic: error: 20: variable not found: ABC%\n"},
+		{`
+10 abc% = 32767
+20 abc% = abc% + 1
+30 print abc%
+run
+`, "basic: error: 20: Overflow\n"},
+		{`
+10 print 234
+20 abc$ = 345
+run
+`, "basic: error: 20: Type mismatch\n 234 \n"},
+		{"print 1; 2; \"a\"; \"b\"\n", " 1  2 ab\n"},
+		{"print \"a\" \"b\" 1\n", "ab 1 \n"},
+		{"print 1;\nprint 2,\nprint 3\n", " 1  2          3 \n"},
+		{"print\n", "\n"},
+		{"print \"a\"; tab(5); \"b\"; spc(3); \"c\"\n", "a   b   c\n"},
+		{"print \"abcdef\"; tab(3); \"b\"\n", "abcdef\n  b\n"},
+		{"print tab(0)\n", "basic: error: Illegal function call\n"},
+		{"print 1, 2, 3, 4, 5, 6, 7\n",
+			" 1             2             3             4             5 \n 6             7 \n"},
+		{"width 20\nprint \"abcdefghijklmnopqrstuvwxyz\"\n", "abcdefghijklmnopqrst\nuvwxyz\n"},
+		{"width 20\nprint \"abcdefghijklmnop\"; 12345\n", "abcdefghijklmnop\n 12345 \n"},
+		{"width 20\nprint 1, 2, 3\n", " 1 \n 2 \n 3 \n"},
+		{"width 30\nprint 1, 2, 3\n", " 1             2 \n 3 \n"},
+		{"width 10\n", "basic: error: Illegal function call\n"},
+		{"print 1 / 3, 2 / 3, 100000 * 100\n", " .3333333      .6666667      1E+07 \n"},
+		{"print 1234567, 123456.7 * 100, 0.001\n", " 1234567       1.234567E+07                .001 \n"},
+		{"print 12345678, .0000001, .0000001234567\n", " 12345678      .0000001      1.234567E-07 \n"},
+		{"print 1.234567890123, 1.2345678901234567 * 1000000000\n",
+			" 1.234567890123              1234567890.123457 \n"},
+		{"print 12345678901234567 * 10\n", " 1.234567890123457D+17 \n"},
+		{`
+10 print "x ="; abc%; tab(20); "y", spc(2);
+20 print
+list
+`, `10 PRINT "x ="; ABC%; TAB(20); "y", SPC(2);
+20 PRINT
+`},
+		{`
+10 abc% = 10
+20 print "x ="; abc%; tab(20); "y", spc(2);
+30 print "z"
+run
+`, "x = 10             y          z\n"},
+		{"print using \"##.## \"; 10.2, 5.3; 66.789\n", "10.20  5.30 66.79 \n"},
+		{"print using \"!: ###\"; \"abc\", 12;\nprint \"x\"\n", "a:  12x\n"},
+		{"print using \"###\"; \"abc\"\n", "basic: error: Type mismatch\n"},
+		{"print using 123; 1\n", "basic: error: Type mismatch\n"},
+		{"print using \"###\" 1\n", "basic: error: expected ; following PRINT USING format\n"},
+		{`
+10 abc$ = "$$#,###.##"
+20 print using abc$; 1234.5; 12.345
+30 print using "Total: ###"; 99
+list
+run
+`, `10 ABC$ = "$$#,###.##"
+20 PRINT USING ABC$; 1234.5; 12.345
+30 PRINT USING "Total: ###"; 99
+ $1,234.50    $12.35
+Total:  99
+`},
 		{`
 10 print 234
 20 print 345
 new
 30 print 456
 run
-`, "456\n"},
+`, " 456 \n"},
+		{`
+10 open "O", #1, "testdata/seq.dat"
+20 print #1, "hello"; 12, - 3.5
+30 write #1, "a, b", 42, - 1.5
+40 print #1, using "##.##"; 3.14159
+50 close #1
+60 open "testdata/seq.dat" for append as 2
+70 print #2, "more"
+80 close
+90 open "I", 1, "testdata/seq.dat"
+100 line input #1, l$
+110 print l$
+120 input #1, a$, n%, x$
+130 print a$; n%; x$
+140 input #1, s$
+150 if eof(1) then goto 190
+160 line input #1, l$
+170 print "[" + l$ + "]"
+180 goto 150
+190 print loc(1), lof(1)
+200 input #1, s$
+run
+`, `hello 12      -3.5 
+a, b 42 -1.5
+[more]
+ 0             46 
+basic: error: 200: Input past end
+`},
+		{`
+open "i", 3, "testdata/missing.dat"
+print #4, 1
+close 16
+open "x", 1, "testdata/seq.dat"
+open "o", 1, "testdata/seq.dat"
+open "o", 1, "testdata/seq.dat"
+input #1, a$
+print eof(1)
+print lof(2)
+`, `basic: error: File not found
+basic: error: Bad file number
+basic: error: Bad file number
+basic: error: Bad file mode
+basic: error: File already open
+basic: error: Bad file mode
+basic: error: Bad file mode
+basic: error: Bad file number
+`},
+		{`
+10 open "testdata/seq.dat" for output as #1
+20 write
+30 write #1, 1, "two"
+40 close #1, 2
+50 input # 1, a$
+list
+run
+`, `10 OPEN "testdata/seq.dat" FOR OUTPUT AS #1
+20 WRITE
+30 WRITE #1, 1, "two"
+40 CLOSE #1, #2
+50 INPUT #1, A$
+
+basic: error: 50: Bad file number
+`},
+		{`
+10 open "R", #1, "testdata/random.dat", 40
+20 field #1, 20 as n$, 2 as q$, 4 as p$, 8 as d$
+30 lset n$ = "widget"
+40 lset q$ = mki$(12)
+50 lset p$ = mks$(1.25)
+60 lset d$ = mkd$(1.123456789)
+70 put #1, 1
+80 rset n$ = "gadget"
+90 lset q$ = mki$(- 7)
+100 put #1
+110 close #1
+120 open "R", 2, "testdata/random.dat", 40
+130 field 2, 20 as a$, 2 as b$, 4 as c$, 8 as e$
+140 field 2, 10 as h$
+150 get 2, 2
+160 print "[" + a$ + "]"; cvi(b$); "[" + h$ + "]"
+170 get #2, 1
+180 print "[" + a$ + "]"; cvi(b$); cvs(c$); cvd(e$); loc(2); lof(2)
+190 a$ = "x"
+200 get #2, 3
+210 print a$; eof(2); loc(2)
+220 z$ = "abcdef"
+230 rset z$ = "xy"
+240 print "[" + z$ + "]"
+250 get #2, 0
+run
+`, `[              gadget]-7 [          ]
+[widget              ] 12  1.25  1.123456789  1  80 
+xTRUE 3 
+[    xy]
+basic: error: 250: Bad record number
+`},
+		{`
+10 open "R", #1, "testdata/random.dat", 8
+20 field #1, 4 as a$, 5 as b$
+30 print cvi("x")
+list
+run
+`, `10 OPEN "R", #1, "testdata/random.dat", 8
+20 FIELD #1, 4 AS A$, 5 AS B$
+30 PRINT CVI("x")
+basic: error: 20: Field overflow
+`},
+		{"print cvi(\"x\")\n", "basic: error: Illegal function call\n"},
+		{"print chr$(65) + chr$(34)\n", "A\"\n"},
+		{"print chr$(256)\n", "basic: error: Illegal function call\n"},
+		{"get #1\n", "basic: error: Bad file number\n"},
+		{"input a$\n", "? basic: error: Input past end\n"},
+		{"input \"name\" a$\n", "basic: error: expected ; or , following the prompt of INPUT\n"},
+		{`
+10 input a, b$
+20 input "name"; n$
+30 input "number", n%
+40 line input l$
+50 line input "line: "; l$
+list
+`, `10 INPUT A, B$
+20 INPUT "name"; N$
+30 INPUT "number", N%
+40 LINE INPUT L$
+50 LINE INPUT "line: "; L$
+`},
+		{"line input #1, a%\n", "basic: error: expected a string variable for LINE INPUT\n"},
+		{"print eof(1, 2)\n", "basic: error: EOF expects 1 argument(s)\n"},
+		{`
+10 open "o", 1, "files/a.bas"
+20 close
+30 open "o", 1, "files/b.bas"
+40 close
+50 open "o", 1, "files/c.dat"
+60 close
+70 files "files/*.bas"
+80 name "files/a.bas" as "files/d.bas"
+90 kill "files/b.bas"
+100 files "files/*.BAS"
+110 print "x";
+120 files "files/?.*"
+130 kill "files/d.bas"
+140 kill "files/c.dat"
+run
+`, "a.bas         b.bas\nd.bas\nx\nc.dat         d.bas\n"},
+		{`
+10 open "o", 1, "files/a.bas"
+20 name "files/a.bas" as "files/b.bas"
+run
+`, "basic: error: 20: File already open\n"},
+		{`
+10 open "o", 1, "files/b.bas"
+20 close
+30 name "files/a.bas" as "files/b.bas"
+run
+`, "basic: error: 30: File already exists\n"},
+		{"kill \"files/b.bas\"\nkill \"files/b.bas\"\n", "basic: error: File not found\n"},
+		{"name \"files/b.bas\" as \"files/c.bas\"\n", "basic: error: File not found\n"},
+		{"files \"files/*.dat\"\n", "basic: error: File not found\n"},
+		{"files \"missing/*\"\n", "basic: error: File not found\n"},
+		{"files 10\n", "basic: error: Type mismatch\n"},
+		{`
+10 if 5 >= 5 then ? "ge"
+20 if 4 => 5 then ? "no" else ? "lt"
+30 ? &HFF; &HFFFF; &O17; 1.5D3; 1E2; 3!
+40 ? "abc
+run
+`, "ge\nlt\n 255 -1  15  1500  100  3 \nabc\n"},
+		{"print 40000%\n", "basic: error: Overflow\n"},
+		{"print &H\n", "basic: error: Syntax error\n"},
+		{"print 1;\t2\r\nprint \"a", " 1  2 \na\n"},
+		{"name \"a\" to \"b\"\n", "basic: error: expected AS following NAME\n"},
+		{`
+10 kill "a.bas"
+20 name "a.bas" as "b.bas"
+30 files "*.bas"
+40 files
+list
+`, `10 KILL "a.bas"
+20 NAME "a.bas" AS "b.bas"
+30 FILES "*.bas"
+40 FILES
+`},
 		{`
 10 abc% = 123
 20 abc$ = "def"
@@ -182,7 +534,7 @@ save "testdata/test.basic"
 		{`
 load "testdata/test.basic"
 run
-`, "123\ndef\n"},
+`, " 123 \ndef\n"},
 		{`
 10 abc% = 123
 20 abc$ = "def"
@@ -206,12 +558,60 @@ delete 20 - 40
 list
 `, `10 ABC% = 123
 50 PRINT ABC%, ABC$
+`},
+		{`
+10 a% = 2
+20 assert a% = 2, "two"
+30 assert a% > 2 , "a% is " + chr$(48 + a%)
+40 print "not reached"
+list
+run
+`, `10 A% = 2
+20 ASSERT A% = 2, "two"
+30 ASSERT A% > 2, "a% is " + CHR$(48 + A%)
+40 PRINT "not reached"
+basic: error: 30: ASSERT failed: a% is 2
+`},
+		{`
+10 end 3
+20 system 2 + 1
+30 end
+40 system
+list
+`, `10 END 3
+20 SYSTEM 2 + 1
+30 END
+40 SYSTEM
+`},
+		{"10 end 256\nrun\n", "basic: error: 10: Illegal function call\n"},
+		{"10 end \"a\"\n", "basic: error: 10: Type mismatch\n"},
+		{"print 1\nsystem\nprint 2\n", " 1 \n"},
+		{"10 print 1\n20 system\n30 print 2\nrun\nprint 3\n", " 1 \n"},
+		{"10 assert 1 = 0\nrun\n", "basic: error: 10: ASSERT failed\n"},
+		{"10 assert 0\n", "basic: error: 10: Type mismatch\n"},
+		{"10 assert 1 = 1, 2\n", "basic: error: 10: Type mismatch\n"},
+		{`
+10 print "one"
+20 print "two"
+save "testdata/merge.basic"
+new
+20 print "TWO"
+30 print "three"
+merge "testdata/merge.basic"
+merge "testdata/missing.basic"
+list
+`, `basic: error: OPEN: open testdata/missing.basic: file does not exist
+10 PRINT "one"
+20 PRINT "two"
+30 PRINT "three"
 `},
 	}
 
+	fsys := NewMemFS()
 	for _, c := range cases {
 		w := &bytes.Buffer{}
 		b := NewBasic(w, w)
+		b.FS = fsys
 		tr := &TokenReader{
 			R: bufio.NewReader(bytes.NewBufferString(c.in)),
 		}
@@ -222,3 +622,337 @@ list
 		}
 	}
 }
+
+func benchmarkProgram(b *testing.B, prog string) {
+	w := &bytes.Buffer{}
+	bas := NewBasic(w, w)
+	bas.Program(&TokenReader{
+		R: bufio.NewReader(bytes.NewBufferString(prog)),
+	})
+	if w.Len() > 0 {
+		b.Fatalf("Program(%s) failed:\n%s", prog, w.String())
+	}
+	img, ok := bas.Compile()
+	if !ok {
+		b.Fatalf("Compile(%s) failed", prog)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bas.Execute(img)
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	benchmarkProgram(b, `
+10 i% = 0
+20 n% = 0
+30 i% = i% + 1
+40 n% = i% * 2
+50 if i% < 10000 goto 30
+`)
+}
+
+func BenchmarkArithmetic(b *testing.B) {
+	benchmarkProgram(b, `
+10 i% = 0
+20 n% = 0
+30 i% = i% + 1
+40 n% = ((i% \ 3) + (i% \ 7)) - ((i% \ 5) * 2)
+50 if i% < 10000 goto 30
+`)
+}
+
+func TestLoadSource(t *testing.T) {
+	cases := []struct {
+		src string
+		out string
+	}{
+		{"10 PRINT 1\n20 PRINT \"A\"\n", " 1 \nA\n"},
+		{"10 PRINT 1\r\n20 PRINT \"A\"\r\n", " 1 \nA\n"},
+		{"10 PRINT 1\r20 PRINT \"A\"\r", " 1 \nA\n"},
+		{"10 PRINT 1\n20 PRINT \"A\"", " 1 \nA\n"},
+		{"10 PRINT 1\r\n20 PRINT \"A\"", " 1 \nA\n"},
+		{"\xEF\xBB\xBF10 PRINT 1\r\n20 PRINT \"A\"\r\n", " 1 \nA\n"},
+		{"10 PRINT 1\r\n20 PRINT \"A\"\r\n\x1A\x1A\x1A", " 1 \nA\n"},
+		{"10 PRINT 1\r\n20 PRINT \"A\"\x1Agarbage", " 1 \nA\n"},
+		{"10\tPRINT\t1\n\t20 PRINT\t\"A\tB\"\n", " 1 \nA\tB\n"},
+		{"\n\r\n10 PRINT 1\n  \n\t\n20 PRINT \"A\n", " 1 \nA\n"},
+		{"10 PRINT 1 ' comment", " 1 \n"},
+		{"10 REM comment", ""},
+		{"", ""},
+	}
+
+	for _, c := range cases {
+		fsys := NewMemFS()
+		writeFile(t, fsys, "test.bas", c.src)
+
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		b.FS = fsys
+		if !b.Load("test.bas") {
+			t.Errorf("Load(%q) failed: %s", c.src, w.String())
+			continue
+		}
+		b.Run()
+		if out := w.String(); out != c.out {
+			t.Errorf("Load(%q) got %q want %q", c.src, out, c.out)
+		}
+	}
+}
+
+func TestLoadCrunched(t *testing.T) {
+	src := "10A%=7\r\n20IFA%>=5THENPRINTA%+1ELSEPRINT\"LOW\"\r\n30GOTO50\r\n" +
+		"40PRINT\"SKIP\"\r\n50PRINT#1,\"DONE\"\r\n"
+	fsys := NewMemFS()
+	writeFile(t, fsys, "test.bas", src)
+
+	for _, crunched := range []bool{false, true} {
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		b.FS = fsys
+		b.Crunched = crunched
+		ok := b.Load("test.bas")
+		if ok != crunched {
+			t.Errorf("Load(%v) got %v want %v: %s", crunched, ok, crunched, w.String())
+		}
+		if !ok {
+			continue
+		}
+		b.Run()
+		want := " 8 \nbasic: error: 50: Bad file number\n"
+		if out := w.String(); out != want {
+			t.Errorf("Run(%v) got %q want %q", crunched, out, want)
+		}
+	}
+
+	writeFile(t, fsys, "sum.bas", "10A=2\n20B=3\n30IFA>1THENPRINTA+B\n40PRINTTAB(3)\"END\"\n")
+	w := &bytes.Buffer{}
+	b := NewBasic(w, w)
+	b.FS = fsys
+	b.Crunched = true
+	if !b.Load("sum.bas") {
+		t.Fatalf("Load(sum.bas) failed: %s", w.String())
+	}
+	b.Run()
+	if out := w.String(); out != " 5 \n  END\n" {
+		t.Errorf("Run(sum.bas) got %q", out)
+	}
+}
+
+func TestCommandVariables(t *testing.T) {
+	cases := []struct {
+		in       string
+		crunched bool
+		out      string
+	}{
+		{`10 LINT=1
+20 PRINT LINT
+30 PROFILE$ = "p"
+40 XREF% = 2
+50 PRINT PROFILE$; XREF%
+run
+LINT = 3
+PRINT LINT
+lint
+`, false, " 1 \np 2 \n 3 \n"},
+		{`10PARAGRAPH=1
+20XREFS=2
+30PRINTPARAGRAPH+XREFS
+40GOTO50
+run
+lint
+`, true, " 3 \n-:40: line 40: GOTO 50: line 50 does not exist\n"},
+	}
+
+	for _, c := range cases {
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		b.Crunched = c.crunched
+		b.Program(&TokenReader{
+			R:        bufio.NewReader(strings.NewReader(c.in)),
+			Crunched: c.crunched,
+		})
+		if out := w.String(); out != c.out {
+			t.Errorf("Program(%q) got %q want %q", c.in, out, c.out)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	src := `10 ' a comment line
+20 A% = (12 + 34) * 56 ' trailing comment
+30 PRINT A%; &HFF; &O17; &17; 1.5#; 2D+3; 1E-2; 7%; 40000; - (3 - 1) - 2
+40 PRINT "say " + CHR$(34) + "hi" + CHR$(34)
+50 REM    spaced remark
+60 IF A% = (2576) THEN PRINT "ok" ELSE PRINT "bad" ' if comment
+70 LET B$ = "x"
+80 DEFINT I-K, N
+90 OPEN "t.dat" FOR OUTPUT AS #1
+100 PRINT #1, USING "##.#"; 1.25
+110 CLOSE #1
+120 OPEN "I", #1, "t.dat"
+130 LINE INPUT #1, C$
+140 CLOSE
+150 IF C$ <> "" GOTO 170
+160 PRINT "never"
+170 WRITE B$, C$
+180 GOSUB 200
+190 END
+200 PRINT "sub"
+210 RETURN
+`
+	fsys := NewMemFS()
+	writeFile(t, fsys, "test.bas", src)
+
+	w := &bytes.Buffer{}
+	b := NewBasic(w, w)
+	b.FS = fsys
+	if !b.Load("test.bas") {
+		t.Fatalf("Load failed: %s", w.String())
+	}
+	b.Save("save.bas")
+	buf, err := fs.ReadFile(fsys, "save.bas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != src {
+		t.Errorf("Save got:\n%swant:\n%s", buf, src)
+	}
+
+	b.Run()
+	want := " 2576  255  15  15  1.5  2000  .01  7  40000  0 \nsay \"hi\"\nok\n\"x\",\" 1.3\"\nsub\n"
+	if out := w.String(); out != want {
+		t.Errorf("Run got %q want %q", out, want)
+	}
+
+	cases := []string{`"`, `a"`, `"a`, `a""b`, `say "hi"`}
+	for _, s := range cases {
+		ve := ValueExpr{Value: StringValue(s)}
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		b.Program(&TokenReader{
+			R: bufio.NewReader(strings.NewReader("10 A$ = " + ve.String() + "\nrun\nprint a$\n")),
+		})
+		if out := w.String(); out != s+"\n" {
+			t.Errorf("%s got %q want %q", ve, out, s+"\n")
+		}
+	}
+}
+
+func TestListPrompts(t *testing.T) {
+	cases := []struct {
+		in, out string
+	}{
+		{"10 INPUT \"A, B: 'C'\t?\"; X\n20 LINE INPUT \"\"; S$\n30 INPUT \"\", Y\n",
+			"10 INPUT \"A, B: 'C'\t?\"; X\n20 LINE INPUT \"\"; S$\n30 INPUT \"\", Y\n"},
+		{"10 INPUT \"A\"\"B\";X\n", "basic: error: expected ; or , following the prompt of INPUT\n"},
+	}
+
+	for _, c := range cases {
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		b.Program(&TokenReader{
+			R: bufio.NewReader(strings.NewReader(c.in + "list\n")),
+		})
+		out := w.String()
+		if out != c.out {
+			t.Errorf("LIST(%q) got %q want %q", c.in, out, c.out)
+			continue
+		}
+		if strings.HasPrefix(out, "basic: error:") {
+			continue
+		}
+
+		// The listing must be read back as the same program.
+		w.Reset()
+		b.New()
+		b.Program(&TokenReader{
+			R: bufio.NewReader(strings.NewReader(out + "list\n")),
+		})
+		if again := w.String(); again != out {
+			t.Errorf("LIST(%q) got %q want %q", out, again, out)
+		}
+	}
+}
+
+func TestLoadTypeMismatch(t *testing.T) {
+	fsys := NewMemFS()
+	writeFile(t, fsys, "good.bas", "10 PRINT \"good\"\n")
+	writeFile(t, fsys, "bad.bas", "20 DEFSTR S\n10 PRINT 1\n30 S = 1\n")
+
+	w := &bytes.Buffer{}
+	b := NewBasic(w, w)
+	b.FS = fsys
+	if !b.Load("good.bas") {
+		t.Fatalf("Load(good.bas) failed: %s", w.String())
+	}
+	if b.Load("bad.bas") {
+		t.Errorf("Load(bad.bas) did not fail")
+	}
+	b.Run()
+	want := "basic: error: 30: Type mismatch\ngood\n"
+	if out := w.String(); out != want {
+		t.Errorf("Load(bad.bas) got %q want %q", out, want)
+	}
+}
+
+func TestRunStatus(t *testing.T) {
+	cases := []struct {
+		src    string
+		status int
+		out    string
+	}{
+		{"10 PRINT 1\n", 0, " 1 \n"},
+		{"10 END 7\n20 PRINT 1\n", 7, ""},
+		{"10 SYSTEM\n", 0, ""},
+		{"10 GOSUB 100\n20 END 1\n100 SYSTEM 255\n", 255, ""},
+		{"10 A% = 32767 + 1\n", ExitRuntimeError, "basic: error: 10: Overflow\n"},
+		{"10 END 1 - 2\n", ExitRuntimeError, "basic: error: 10: Illegal function call\n"},
+		{"10 RETURN\n", ExitRuntimeError, "basic: error: 10: RETURN without a GOSUB\n"},
+	}
+
+	for _, c := range cases {
+		fsys := NewMemFS()
+		writeFile(t, fsys, "t.bas", c.src)
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		b.FS = fsys
+		if !b.Load("t.bas") {
+			t.Fatalf("Load(%q) failed: %s", c.src, w.String())
+		}
+		if status := b.Run(); status != c.status {
+			t.Errorf("Run(%q) got %d want %d", c.src, status, c.status)
+		}
+		if out := w.String(); out != c.out {
+			t.Errorf("Run(%q) got %q want %q", c.src, out, c.out)
+		}
+	}
+
+	w := &bytes.Buffer{}
+	b := NewBasic(w, w)
+	b.Program(&TokenReader{
+		R: bufio.NewReader(bytes.NewBufferString("10 GOTO 20\n20 GOTO 10\n")),
+	})
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				b.Interrupt()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	status := b.Run()
+	close(done)
+	if status != ExitBreak {
+		t.Errorf("Run(interrupted) got %d want %d", status, ExitBreak)
+	}
+	if out := w.String(); out != "Break in 10\n" && out != "Break in 20\n" {
+		t.Errorf("Run(interrupted) got %q", out)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// conformRun runs the program fn in fsys with in as the keyboard, and returns everything it
+// wrote to the screen, including the input it read and any errors.
+func conformRun(fsys FS, fn string, in []byte) []byte {
+	var out bytes.Buffer
+	b := NewBasic(&out, &out)
+	b.FS = fsys
+	b.In = bufio.NewReader(bytes.NewReader(in))
+	b.Echo = true
+	if b.Load(fn) {
+		b.Run()
+	}
+	return out.Bytes()
+}
+
+// firstDiff describes the first line where got differs from want.
+func firstDiff(got, want []byte) string {
+	gl := strings.SplitAfter(string(got), "\n")
+	wl := strings.SplitAfter(string(want), "\n")
+	for i := 0; ; i += 1 {
+		g, w := "EOF", "EOF"
+		if i < len(gl) && gl[i] != "" {
+			g = fmt.Sprintf("%q", gl[i])
+		}
+		if i < len(wl) && wl[i] != "" {
+			w = fmt.Sprintf("%q", wl[i])
+		}
+		if g != w {
+			return fmt.Sprintf("line %d: got %s want %s", i+1, g, w)
+		}
+	}
+}
+
+// Conform runs the conformance suite in fsys: each program, x.bas, is run with x.in, if there
+// is one, as the keyboard, and what it writes to the screen, including the input read and any
+// errors, must be the same as x.out. Programs ending in _test.bas are left for RunTests. If
+// update is set, the .out files are written instead. The results are reported to w, and the
+// numbers of programs which passed and failed are returned.
+func Conform(w io.Writer, fsys FS, update bool) (int, int) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		fmt.Fprintf(w, "--- FAIL: %s\n", err)
+		fmt.Fprintln(w, "FAIL: 0 passed, 1 failed")
+		return 0, 1
+	}
+	var names []string
+	for _, de := range entries {
+		name := strings.ToLower(de.Name())
+		if !de.IsDir() && strings.HasSuffix(name, ".bas") &&
+			!strings.HasSuffix(name, "_test.bas") {
+			names = append(names, de.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var passed, failed int
+	for _, fn := range names {
+		base := fn[:len(fn)-len(".bas")]
+		in, err := fs.ReadFile(fsys, base+".in")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(w, "--- FAIL: %s\n    %s\n", fn, err)
+			failed += 1
+			continue
+		}
+		got := conformRun(fsys, fn, in)
+
+		want, err := fs.ReadFile(fsys, base+".out")
+		if update {
+			if err != nil || !bytes.Equal(got, want) {
+				if err := writeGolden(fsys, base+".out", got); err != nil {
+					fmt.Fprintf(w, "--- FAIL: %s\n    %s\n", fn, err)
+					failed += 1
+					continue
+				}
+				fmt.Fprintf(w, "updated\t%s\n", base+".out")
+			}
+			passed += 1
+		} else if err != nil {
+			fmt.Fprintf(w, "--- FAIL: %s\n    %s\n", fn, err)
+			failed += 1
+		} else if !bytes.Equal(got, want) {
+			fmt.Fprintf(w, "--- FAIL: %s\n    %s\n", fn, firstDiff(got, want))
+			failed += 1
+		} else {
+			passed += 1
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(w, "FAIL: %d passed, %d failed\n", passed, failed)
+	} else {
+		fmt.Fprintf(w, "PASS: %d passed\n", passed)
+	}
+	return passed, failed
+}
+
+func writeGolden(fsys FS, fn string, data []byte) error {
+	f, err := Create(fsys, fn)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
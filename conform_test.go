@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "write the .out files in testdata")
+
+// TestConform runs the programs in a copy of testdata, so that the files they write are not
+// left in the source tree; with -update, the .out files are copied back.
+func TestConform(t *testing.T) {
+	entries, err := os.ReadDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := NewMemFS()
+	for _, de := range entries {
+		if de.IsDir() {
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join("testdata", de.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, fsys, de.Name(), string(buf))
+	}
+
+	var w bytes.Buffer
+	if _, failed := Conform(&w, fsys, *update); failed > 0 {
+		t.Errorf("Conform(testdata) failed:\n%s", w.String())
+	}
+	if !*update {
+		return
+	}
+
+	outs, err := fs.Glob(fsys, "*.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range outs {
+		buf, err := fs.ReadFile(fsys, fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join("testdata", fn), buf, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConformMemFS(t *testing.T) {
+	fsys := NewMemFS()
+	writeFile(t, fsys, "a.bas", "10 INPUT \"n\"; N%\n20 PRINT N% + 1\n")
+	writeFile(t, fsys, "a.in", "41\n")
+	writeFile(t, fsys, "b.bas", "10 PRINT \"b\"\n")
+	writeFile(t, fsys, "b.out", "B\n")
+	writeFile(t, fsys, "c.bas", "10 PRINT \"c\"\n")
+	writeFile(t, fsys, "c_test.bas", "10 REM TEST c\n20 RETURN\n")
+
+	cases := []struct {
+		update         bool
+		passed, failed int
+		out            string
+	}{
+		{false, 0, 3, `--- FAIL: a.bas
+    open a.out: file does not exist
+--- FAIL: b.bas
+    line 1: got "b\n" want "B\n"
+--- FAIL: c.bas
+    open c.out: file does not exist
+FAIL: 0 passed, 3 failed
+`},
+		{true, 3, 0, `updated	a.out
+updated	b.out
+updated	c.out
+PASS: 3 passed
+`},
+		{false, 3, 0, "PASS: 3 passed\n"},
+	}
+
+	for _, c := range cases {
+		var w bytes.Buffer
+		passed, failed := Conform(&w, fsys, c.update)
+		if passed != c.passed || failed != c.failed {
+			t.Errorf("Conform(%v) got %d passed %d failed want %d passed %d failed", c.update,
+				passed, failed, c.passed, c.failed)
+		}
+		if out := w.String(); out != c.out {
+			t.Errorf("Conform(%v) got:\n%swant:\n%s", c.update, out, c.out)
+		}
+	}
+
+	if got := conformRun(fsys, "a.bas", []byte("41\n")); string(got) != "n? 41\n 42 \n" {
+		t.Errorf("conformRun(a.bas) got %q", got)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/btree"
+)
+
+// Coverage is, by line number, how many times each line of the program with code ran, and
+// how many times each way of the IFs on it was taken.
+type Coverage struct {
+	Lines    map[int]int64
+	Branches map[int][]Branch
+}
+
+func newCoverage() Coverage {
+	return Coverage{Lines: map[int]int64{}, Branches: map[int][]Branch{}}
+}
+
+// Coverage returns the coverage of the program from b.Profile, which has the runs of the
+// program since it was set.
+func (b *Basic) Coverage() (Coverage, bool) {
+	defTypes := b.DefTypes
+	img, ok := b.Compile()
+	b.DefTypes = defTypes
+	if !ok {
+		return Coverage{}, false
+	}
+
+	cov := newCoverage()
+	branches := imageBranches(img)
+	for k, line := range img.Lines {
+		// The OpEnd at the end of the image doesn't belong to the last line.
+		end := len(img.Code) - 1
+		if k+1 < len(img.Lines) {
+			end = img.Lines[k+1].PC
+		}
+		if line.PC >= end {
+			continue
+		}
+		if lp, ok := b.Profile.Lines[line.Number]; ok {
+			cov.Lines[line.Number] = lp.Count
+		} else {
+			cov.Lines[line.Number] = 0
+		}
+		if len(branches[k]) > 0 {
+			cov.Branches[line.Number] = make([]Branch, len(branches[k]))
+			copy(cov.Branches[line.Number], b.Profile.Branches[line.Number])
+		}
+	}
+	return cov, true
+}
+
+// addCoverage adds the counts of other, which are by the lines of the file with the program, to
+// cov, which is by line number. Lines which are not in cov are ignored.
+func (b *Basic) addCoverage(cov, other Coverage) {
+	for n, count := range cov.Lines {
+		cov.Lines[n] = count + other.Lines[b.sourceLine(n)]
+	}
+	for n, branches := range cov.Branches {
+		for i, br := range other.Branches[b.sourceLine(n)] {
+			if i < len(branches) {
+				branches[i].Then += br.Then
+				branches[i].Else += br.Else
+			}
+		}
+	}
+}
+
+// sourceLine returns the line of the file that line n was loaded from, or n if it wasn't.
+func (b *Basic) sourceLine(n int) int {
+	if l, ok := b.Source[n]; ok {
+		return l
+	}
+	return n
+}
+
+// WriteLCOV writes cov to w as an LCOV tracefile record for the file fn with the program.
+// The lines are the lines of the file, and each IF is a block with the THEN as branch 0 and the
+// ELSE as branch 1.
+func (b *Basic) WriteLCOV(w io.Writer, fn string, cov Coverage) {
+	var numbers []int
+	for n := range cov.Lines {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	fmt.Fprintf(w, "TN:\nSF:%s\n", fn)
+	var found, hit int
+	for _, n := range numbers {
+		for i, br := range cov.Branches[n] {
+			for j, taken := range []int64{br.Then, br.Else} {
+				found += 1
+				if cov.Lines[n] == 0 {
+					fmt.Fprintf(w, "BRDA:%d,%d,%d,-\n", b.sourceLine(n), i, j)
+					continue
+				}
+				if taken > 0 {
+					hit += 1
+				}
+				fmt.Fprintf(w, "BRDA:%d,%d,%d,%d\n", b.sourceLine(n), i, j, taken)
+			}
+		}
+	}
+	fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", found, hit)
+
+	hit = 0
+	for _, n := range numbers {
+		if cov.Lines[n] > 0 {
+			hit += 1
+		}
+		fmt.Fprintf(w, "DA:%d,%d\n", b.sourceLine(n), cov.Lines[n])
+	}
+	fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(numbers), hit)
+}
+
+// ReadLCOV reads an LCOV tracefile from r, and returns the coverage in the records for the
+// file fn, by the lines of the file, and the other records.
+func ReadLCOV(r io.Reader, fn string) (Coverage, []string, error) {
+	cov := newCoverage()
+	var others []string
+	var record []string
+	mine := false
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		record = append(record, line)
+		key, val, _ := strings.Cut(line, ":")
+		switch key {
+		case "SF":
+			mine = val == fn
+		case "DA":
+			if !mine {
+				continue
+			}
+			fields := strings.Split(val, ",")
+			if len(fields) < 2 {
+				return cov, nil, fmt.Errorf("bad line: %s", line)
+			}
+			l, err1 := strconv.Atoi(fields[0])
+			count, err2 := strconv.ParseInt(fields[1], 10, 64)
+			if err1 != nil || err2 != nil {
+				return cov, nil, fmt.Errorf("bad line: %s", line)
+			}
+			cov.Lines[l] += count
+		case "BRDA":
+			if !mine {
+				continue
+			}
+			fields := strings.Split(val, ",")
+			if len(fields) != 4 {
+				return cov, nil, fmt.Errorf("bad line: %s", line)
+			}
+			l, err1 := strconv.Atoi(fields[0])
+			i, err2 := strconv.Atoi(fields[1])
+			j, err3 := strconv.Atoi(fields[2])
+			var taken int64
+			var err4 error
+			if fields[3] != "-" {
+				taken, err4 = strconv.ParseInt(fields[3], 10, 64)
+			}
+			if err1 != nil || err2 != nil || err3 != nil || err4 != nil || i < 0 ||
+				j < 0 || j > 1 {
+				return cov, nil, fmt.Errorf("bad line: %s", line)
+			}
+			branches := cov.Branches[l]
+			for len(branches) <= i {
+				branches = append(branches, Branch{})
+			}
+			if j == 0 {
+				branches[i].Then += taken
+			} else {
+				branches[i].Else += taken
+			}
+			cov.Branches[l] = branches
+		case "end_of_record":
+			if !mine {
+				others = append(others, record...)
+			}
+			record = nil
+			mine = false
+		}
+	}
+	if err := s.Err(); err != nil {
+		return cov, nil, err
+	}
+	if len(record) > 0 {
+		return cov, nil, errors.New("missing end_of_record")
+	}
+	return cov, others, nil
+}
+
+// WriteCoverage writes the program to w with how many times each line ran: lines which never
+// ran are marked #####, and lines without code -. Below each line which ran are the ways of
+// its IFs which were never taken, and at the end is a summary.
+func (b *Basic) WriteCoverage(w io.Writer, cov Coverage) {
+	var lines, linesHit, branches, branchesHit int
+	b.Code.Ascend(
+		func(item btree.Item) bool {
+			line := item.(Line)
+			var sb strings.Builder
+			line.Stmt.Print(&sb)
+
+			count, ok := cov.Lines[line.Number]
+			mark := "-"
+			if ok {
+				lines += 1
+				if count > 0 {
+					linesHit += 1
+					mark = strconv.FormatInt(count, 10)
+				} else {
+					mark = "#####"
+				}
+			}
+			fmt.Fprintf(w, "%9s  %d %s\n", mark, line.Number, sb.String())
+
+			for i, br := range cov.Branches[line.Number] {
+				branches += 2
+				for _, way := range []struct {
+					name  string
+					taken int64
+				}{{"THEN", br.Then}, {"ELSE", br.Else}} {
+					if way.taken > 0 {
+						branchesHit += 1
+					} else if count > 0 {
+						fmt.Fprintf(w, "%9s  IF %d: %s never taken\n", "", i+1, way.name)
+					}
+				}
+			}
+			return true
+		})
+	fmt.Fprintf(w, "%d of %d lines ran, %d of %d branches taken\n", linesHit, lines,
+		branchesHit, branches)
+}
+
+// SaveCoverage gets the coverage of the program, fn, from b.Profile, adds the coverage in the
+// LCOV tracefile lcov if there is one, and writes the coverage back to lcov, and as a listing
+// to the file listing.
+func (b *Basic) SaveCoverage(fn, lcov, listing string) bool {
+	cov, ok := b.Coverage()
+	if !ok {
+		return false
+	}
+
+	var others []string
+	f, err := b.FS.Open(lcov)
+	if err == nil {
+		var old Coverage
+		old, others, err = ReadLCOV(f, fn)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(b.ErrW, "basic: error: COVER: %s: %s\n", lcov, err)
+			return false
+		}
+		b.addCoverage(cov, old)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(b.ErrW, "basic: error: COVER: %s\n", err)
+		return false
+	}
+
+	write := func(name string, fn func(w io.Writer)) bool {
+		f, err := Create(b.FS, name)
+		if err == nil {
+			w := bufio.NewWriter(f)
+			fn(w)
+			err = w.Flush()
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(b.ErrW, "basic: error: COVER: %s\n", err)
+			return false
+		}
+		return true
+	}
+	return write(lcov,
+		func(w io.Writer) {
+			for _, line := range others {
+				fmt.Fprintln(w, line)
+			}
+			b.WriteLCOV(w, fn, cov)
+		}) &&
+		write(listing,
+			func(w io.Writer) {
+				b.WriteCoverage(w, cov)
+			})
+}
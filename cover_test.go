@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	src := `10 REM coverage
+20 OPEN "I", #1, "in.txt"
+30 INPUT #1, A%
+40 IF A% > 0 THEN PRINT "pos" ELSE PRINT "neg"
+50 IF A% = 7 GOTO 80
+
+60 PRINT "not seven"
+70 END
+80 PRINT "seven"
+`
+	fsys := NewMemFS()
+	writeFile(t, fsys, "t.bas", src)
+	writeFile(t, fsys, "t.lcov", `TN:
+SF:other.bas
+DA:1,3
+LF:1
+LH:1
+end_of_record
+`)
+
+	cases := []struct {
+		input   string
+		lcov    string
+		listing string
+	}{
+		{"5\n", `TN:
+SF:other.bas
+DA:1,3
+LF:1
+LH:1
+end_of_record
+TN:
+SF:t.bas
+BRDA:4,0,0,1
+BRDA:4,0,1,0
+BRDA:5,0,0,0
+BRDA:5,0,1,1
+BRF:4
+BRH:2
+DA:2,1
+DA:3,1
+DA:4,1
+DA:5,1
+DA:7,1
+DA:8,1
+DA:9,0
+LF:7
+LH:6
+end_of_record
+`, `        -  10 REM coverage
+        1  20 OPEN "I", #1, "in.txt"
+        1  30 INPUT #1, A%
+        1  40 IF A% > 0 THEN PRINT "pos" ELSE PRINT "neg"
+           IF 1: ELSE never taken
+        1  50 IF A% = 7 GOTO 80
+           IF 1: THEN never taken
+        1  60 PRINT "not seven"
+        1  70 END
+    #####  80 PRINT "seven"
+6 of 7 lines ran, 2 of 4 branches taken
+`},
+		{"7\n", `TN:
+SF:other.bas
+DA:1,3
+LF:1
+LH:1
+end_of_record
+TN:
+SF:t.bas
+BRDA:4,0,0,2
+BRDA:4,0,1,0
+BRDA:5,0,0,1
+BRDA:5,0,1,1
+BRF:4
+BRH:3
+DA:2,2
+DA:3,2
+DA:4,2
+DA:5,2
+DA:7,1
+DA:8,1
+DA:9,1
+LF:7
+LH:7
+end_of_record
+`, `        -  10 REM coverage
+        2  20 OPEN "I", #1, "in.txt"
+        2  30 INPUT #1, A%
+        2  40 IF A% > 0 THEN PRINT "pos" ELSE PRINT "neg"
+           IF 1: ELSE never taken
+        2  50 IF A% = 7 GOTO 80
+        1  60 PRINT "not seven"
+        1  70 END
+        1  80 PRINT "seven"
+7 of 7 lines ran, 3 of 4 branches taken
+`},
+	}
+
+	for _, c := range cases {
+		writeFile(t, fsys, "in.txt", c.input)
+
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		b.FS = fsys
+		if !b.Load("t.bas") {
+			t.Fatalf("Load failed: %s", w.String())
+		}
+		b.Profile = NewProfile()
+		b.Run()
+		if !b.SaveCoverage("t.bas", "t.lcov", "t.cover") {
+			t.Fatalf("SaveCoverage(%q) failed: %s", c.input, w.String())
+		}
+
+		if buf, err := fs.ReadFile(fsys, "t.lcov"); err != nil {
+			t.Errorf("ReadFile(t.lcov) failed with %s", err)
+		} else if string(buf) != c.lcov {
+			t.Errorf("SaveCoverage(%q) lcov got:\n%swant:\n%s", c.input, buf, c.lcov)
+		}
+		if buf, err := fs.ReadFile(fsys, "t.cover"); err != nil {
+			t.Errorf("ReadFile(t.cover) failed with %s", err)
+		} else if string(buf) != c.listing {
+			t.Errorf("SaveCoverage(%q) listing got:\n%swant:\n%s", c.input, buf, c.listing)
+		}
+	}
+
+	writeFile(t, fsys, "t.lcov", "TN:\nSF:t.bas\nDA:2\n")
+	w := &bytes.Buffer{}
+	b := NewBasic(w, w)
+	b.FS = fsys
+	b.Load("t.bas")
+	b.Profile = NewProfile()
+	if b.SaveCoverage("t.bas", "t.lcov", "t.cover") {
+		t.Errorf("SaveCoverage did not fail with a bad tracefile")
+	} else if out := w.String(); out != "basic: error: COVER: t.lcov: bad line: DA:2\n" {
+		t.Errorf("SaveCoverage got %q", out)
+	}
+}
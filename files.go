@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBadFileNumber     = errors.New("Bad file number")
+	ErrFileNotFound      = errors.New("File not found")
+	ErrFileAlreadyOpen   = errors.New("File already open")
+	ErrBadFileMode       = errors.New("Bad file mode")
+	ErrBadFileName       = errors.New("Bad file name")
+	ErrFileAlreadyExists = errors.New("File already exists")
+	ErrPermissionDenied  = errors.New("Permission denied")
+	ErrInputPastEnd      = errors.New("Input past end")
+	ErrFieldOverflow     = errors.New("Field overflow")
+	ErrBadRecordNumber   = errors.New("Bad record number")
+)
+
+const (
+	// MaxFiles is the largest file number which can be used with OPEN.
+	MaxFiles = 15
+
+	// RecordSize is the size of the records counted by LOC for sequential files, and the
+	// record length of a random file if OPEN does not give one.
+	RecordSize = 128
+
+	// MaxRecord is the largest record number, and the largest record length, of a random
+	// file.
+	MaxRecord = 32767
+
+	// ctrlZ marks the end of a text file.
+	ctrlZ = 0x1A
+)
+
+// File is a file opened by OPEN with a mode of 'I' (input), 'O' (output), 'A' (append), or
+// 'R' (random). Output goes through a Printer so that PRINT # formats lines the same way that
+// PRINT does on the screen. A random file reads and writes whole records through buf.
+type File struct {
+	Mode byte
+	Name string
+	Printer
+	f FSFile
+	r *bufio.Reader
+	w *bufio.Writer
+	n int64 // bytes read or written
+
+	buf []byte
+	rec int  // last record read or written
+	eof bool // the last GET was past the end of the file
+}
+
+// Field is a string variable which FIELD has made an alias for part of the record buffer of
+// a random file. Assigning to the variable other than with LSET or RSET ends the alias.
+type Field struct {
+	File   *File
+	Offset int
+	Length int
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.n += int64(n)
+	return n, err
+}
+
+// peek returns the next byte of input without consuming it; a Ctrl-Z ends the input the
+// same way the end of the file does.
+func (f *File) peek() (byte, bool) {
+	buf, err := f.r.Peek(1)
+	if err != nil || buf[0] == ctrlZ {
+		return 0, false
+	}
+	return buf[0], true
+}
+
+func (f *File) next() {
+	f.r.ReadByte()
+	f.n += 1
+}
+
+func (f *File) skipSpaces() {
+	for {
+		ch, ok := f.peek()
+		if !ok || (ch != ' ' && ch != '\t') {
+			return
+		}
+		f.next()
+	}
+}
+
+// skipSeparator consumes the comma or line ending which follows an item.
+func (f *File) skipSeparator() {
+	f.skipSpaces()
+	ch, ok := f.peek()
+	if !ok {
+		return
+	}
+	if ch == ',' || ch == '\n' {
+		f.next()
+	} else if ch == '\r' {
+		f.next()
+		if ch, ok = f.peek(); ok && ch == '\n' {
+			f.next()
+		}
+	}
+}
+
+// readUntil returns the input up to, but not including, the first byte which is in stop.
+func (f *File) readUntil(stop string) string {
+	var sb strings.Builder
+	for {
+		ch, ok := f.peek()
+		if !ok || strings.IndexByte(stop, ch) >= 0 {
+			return sb.String()
+		}
+		sb.WriteByte(ch)
+		f.next()
+	}
+}
+
+// ReadItem reads the next item for INPUT #. Items are separated by commas or line endings;
+// a string may be quoted, and a number ends at a space as well.
+func (f *File) ReadItem(t Type) (Value, error) {
+	for {
+		ch, ok := f.peek()
+		if !ok {
+			return Value{}, ErrInputPastEnd
+		}
+		if ch != ' ' && ch != '\t' && ch != '\r' && ch != '\n' {
+			break
+		}
+		f.next()
+	}
+
+	if t == StringType {
+		var s string
+		if ch, _ := f.peek(); ch == '"' {
+			f.next()
+			s = f.readUntil("\"")
+			if _, ok := f.peek(); ok {
+				f.next()
+			}
+		} else {
+			s = strings.TrimRight(f.readUntil(",\r\n"), " \t")
+		}
+		f.skipSeparator()
+		return StringValue(s), nil
+	}
+
+	s := f.readUntil(" \t,\r\n")
+	f.skipSeparator()
+	n, err := strconv.ParseFloat(strings.Replace(strings.ToUpper(s), "D", "E", 1), 64)
+	if err != nil {
+		return Value{}, ErrTypeMismatch
+	}
+	return NumberValue(n, t)
+}
+
+// ReadLine reads the rest of the current line for LINE INPUT #.
+func (f *File) ReadLine() (string, error) {
+	if _, ok := f.peek(); !ok {
+		return "", ErrInputPastEnd
+	}
+	s := f.readUntil("\r\n")
+	if ch, ok := f.peek(); ok && ch == '\r' {
+		f.next()
+	}
+	if ch, ok := f.peek(); ok && ch == '\n' {
+		f.next()
+	}
+	return s, nil
+}
+
+// EOF returns true if there is no more input, or for a random file, if the last GET was past
+// the end of the file.
+func (f *File) EOF() (bool, error) {
+	if f.Mode == 'R' {
+		return f.eof, nil
+	} else if f.Mode != 'I' {
+		return false, ErrBadFileMode
+	}
+	_, ok := f.peek()
+	return !ok, nil
+}
+
+// Loc returns the number of whole records read or written so far, or for a random file, the
+// number of the last record read or written.
+func (f *File) Loc() int64 {
+	if f.Mode == 'R' {
+		return int64(f.rec)
+	}
+	return f.n / RecordSize
+}
+
+func (f *File) record(rec int) (int64, error) {
+	if rec < 1 || rec > MaxRecord {
+		return 0, ErrBadRecordNumber
+	}
+	f.rec = rec
+	return int64(rec-1) * int64(len(f.buf)), nil
+}
+
+// Get reads record number rec, counting from one, into the record buffer. Reading past the
+// end of the file fills the buffer with zeros.
+func (f *File) Get(rec int) error {
+	off, err := f.record(rec)
+	if err != nil {
+		return err
+	}
+	n, err := f.f.ReadAt(f.buf, off)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for i := n; i < len(f.buf); i += 1 {
+		f.buf[i] = 0
+	}
+	f.eof = n == 0
+	return nil
+}
+
+// Put writes the record buffer to record number rec.
+func (f *File) Put(rec int) error {
+	off, err := f.record(rec)
+	if err != nil {
+		return err
+	}
+	_, err = f.f.WriteAt(f.buf, off)
+	return err
+}
+
+// Len returns the length of the file in bytes.
+func (f *File) Len() (int64, error) {
+	if f.w != nil {
+		if err := f.w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	fi, err := f.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (f *File) Close() error {
+	var err error
+	if f.w != nil {
+		err = f.w.Flush()
+	}
+	if cerr := f.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Open opens file number n for the mode given by the first letter of mode; the mode is one
+// of "I", "O", "A", or "R". A random file has records of length bytes, or RecordSize bytes
+// if length is zero.
+func (b *Basic) Open(mode string, n int, name string, length int) error {
+	if n < 1 || n > MaxFiles {
+		return ErrBadFileNumber
+	}
+	if b.Files[n] != nil {
+		return ErrFileAlreadyOpen
+	}
+	if name == "" {
+		return ErrBadFileName
+	}
+
+	var m byte
+	if mode != "" {
+		m = strings.ToUpper(mode)[0]
+	}
+	var flag int
+	switch m {
+	case 'I':
+		flag = os.O_RDONLY
+	case 'O':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case 'A':
+		flag = os.O_WRONLY | os.O_APPEND | os.O_CREATE
+	case 'R':
+		if length == 0 {
+			length = RecordSize
+		} else if length < 1 || length > MaxRecord {
+			return ErrIllegalFunctionCall
+		}
+		flag = os.O_RDWR | os.O_CREATE
+	default:
+		return ErrBadFileMode
+	}
+	f, err := b.FS.OpenFile(name, flag, 0666)
+	if err != nil {
+		return fileError(err)
+	}
+
+	file := &File{Mode: m, Name: name, f: f}
+	if m == 'I' {
+		file.r = bufio.NewReader(f)
+	} else if m == 'R' {
+		file.buf = make([]byte, length)
+	} else {
+		file.w = bufio.NewWriter(f)
+		file.Printer = Printer{W: file, Width: InfiniteWidth}
+	}
+	b.Files[n] = file
+	return nil
+}
+
+// fileError returns the BASIC-80 error for an error from the filesystem.
+func fileError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrFileNotFound
+	case errors.Is(err, fs.ErrExist):
+		return ErrFileAlreadyExists
+	case errors.Is(err, fs.ErrPermission):
+		return ErrPermissionDenied
+	case errors.Is(err, fs.ErrInvalid):
+		return ErrBadFileName
+	}
+	return err
+}
+
+// isOpen returns true if the file name is open.
+func (b *Basic) isOpen(name string) bool {
+	name = path.Clean(name)
+	for _, f := range b.Files {
+		if f != nil && path.Clean(f.Name) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Kill deletes the file name, which must not be open.
+func (b *Basic) Kill(name string) error {
+	if name == "" {
+		return ErrBadFileName
+	}
+	if b.isOpen(name) {
+		return ErrFileAlreadyOpen
+	}
+	fi, err := fs.Stat(b.FS, name)
+	if err != nil {
+		return fileError(err)
+	} else if fi.IsDir() {
+		return ErrFileNotFound
+	}
+	return fileError(b.FS.Remove(name))
+}
+
+// Rename renames the file oldname to newname, which must not already exist.
+func (b *Basic) Rename(oldname, newname string) error {
+	if oldname == "" || newname == "" {
+		return ErrBadFileName
+	}
+	if b.isOpen(oldname) {
+		return ErrFileAlreadyOpen
+	}
+	fi, err := fs.Stat(b.FS, oldname)
+	if err != nil {
+		return fileError(err)
+	} else if fi.IsDir() {
+		return ErrFileNotFound
+	}
+	if _, err := fs.Stat(b.FS, newname); err == nil {
+		return ErrFileAlreadyExists
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fileError(err)
+	}
+	return fileError(b.FS.Rename(oldname, newname))
+}
+
+// ListFiles returns the names of the files matching pattern, which can use the wildcards
+// '*' and '?' in its last element; case is ignored. An empty pattern matches all of the files
+// in the current directory. Directories are returned with a trailing '/'.
+func (b *Basic) ListFiles(pattern string) ([]string, error) {
+	dir, pat := ".", "*"
+	if pattern != "" {
+		dir, pat = path.Split(pattern)
+		if dir == "" {
+			dir = "."
+		} else if dir != "/" {
+			dir = strings.TrimSuffix(dir, "/")
+		}
+		pat = strings.ToUpper(pat)
+		if _, err := path.Match(pat, ""); err != nil {
+			return nil, ErrBadFileName
+		}
+	}
+
+	entries, err := fs.ReadDir(b.FS, dir)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	var names []string
+	for _, de := range entries {
+		if ok, _ := path.Match(pat, strings.ToUpper(de.Name())); !ok {
+			continue
+		}
+		name := de.Name()
+		if de.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, ErrFileNotFound
+	}
+	return names, nil
+}
+
+// File returns open file number n; if modes is not empty, the file must have been opened
+// with one of the modes.
+func (b *Basic) File(n int, modes string) (*File, error) {
+	if n < 1 || n > MaxFiles || b.Files[n] == nil {
+		return nil, ErrBadFileNumber
+	}
+	f := b.Files[n]
+	if modes != "" && strings.IndexByte(modes, f.Mode) < 0 {
+		return nil, ErrBadFileMode
+	}
+	return f, nil
+}
+
+// Close closes file number n; it is not an error if the file is not open.
+func (b *Basic) Close(n int) error {
+	if n < 1 || n > MaxFiles {
+		return ErrBadFileNumber
+	}
+	f := b.Files[n]
+	if f == nil {
+		return nil
+	}
+	b.Files[n] = nil
+	b.unfield(f)
+	return f.Close()
+}
+
+// CloseFiles closes all open files; it is done when a program is run and when it ends.
+func (b *Basic) CloseFiles() {
+	for n := range b.Files {
+		if b.Files[n] != nil {
+			b.Files[n].Close()
+			b.Files[n] = nil
+		}
+	}
+	b.Fields = nil
+}
+
+// unfield ends the aliases of the field variables of a file; the variables keep their
+// current values.
+func (b *Basic) unfield(f *File) {
+	for slot, fld := range b.Fields {
+		if fld.File == f {
+			delete(b.Fields, slot)
+		}
+	}
+}
+
+// Field makes the string variable in slot an alias for length bytes of the record buffer of
+// f starting at offset.
+func (b *Basic) Field(f *File, offset, length, slot int) error {
+	if length < 0 || length > 255 {
+		return ErrIllegalFunctionCall
+	}
+	if offset+length > len(f.buf) {
+		return ErrFieldOverflow
+	}
+	if b.Fields == nil {
+		b.Fields = map[int]Field{}
+	}
+	b.Fields[slot] = Field{f, offset, length}
+	b.Vars[slot] = StringValue(string(f.buf[offset : offset+length]))
+	return nil
+}
+
+// syncFields updates the field variables of f from its record buffer.
+func (b *Basic) syncFields(f *File) {
+	for slot, fld := range b.Fields {
+		if fld.File == f {
+			b.Vars[slot] = StringValue(string(f.buf[fld.Offset : fld.Offset+fld.Length]))
+		}
+	}
+}
+
+// SetField implements LSET, or RSET if right is set: s is padded with spaces, or truncated,
+// to the length of the variable in slot and then stored in it. If the variable is a field
+// variable, s is stored in the record buffer.
+func (b *Basic) SetField(slot int, s string, right bool) {
+	fld, ok := b.Fields[slot]
+	n := len(b.Vars[slot].String)
+	if ok {
+		n = fld.Length
+	}
+	if len(s) > n {
+		s = s[:n]
+	} else if right {
+		s = strings.Repeat(" ", n-len(s)) + s
+	} else {
+		s += strings.Repeat(" ", n-len(s))
+	}
+
+	if ok {
+		copy(fld.File.buf[fld.Offset:], s)
+		b.syncFields(fld.File)
+	} else {
+		b.Vars[slot] = StringValue(s)
+	}
+}
+
+// MKI$, MKS$, and MKD$ convert numbers to strings of 2, 4, and 8 bytes for storing in random
+// files, and CVI, CVS, and CVD convert them back. Integers are little endian, and single and
+// double precision numbers are in Microsoft Binary Format.
+
+func mki(n int) string {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], uint16(n))
+	return string(buf[:])
+}
+
+func cvi(s string) (Value, error) {
+	if len(s) < 2 {
+		return Value{}, ErrIllegalFunctionCall
+	}
+	return IntegerValue(int(int16(binary.LittleEndian.Uint16([]byte(s))))), nil
+}
+
+func cvs(s string) (Value, error) {
+	f, err := SingleMBF(s)
+	if err != nil {
+		return Value{}, err
+	}
+	return SingleValue(f), nil
+}
+
+func cvd(s string) (Value, error) {
+	f, err := DoubleMBF(s)
+	if err != nil {
+		return Value{}, err
+	}
+	return DoubleValue(f), nil
+}
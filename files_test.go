@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestReadItem(t *testing.T) {
+	cases := []struct {
+		in   string
+		typ  Type
+		vals []Value
+		err  error
+	}{
+		{"abc,def\n", StringType, []Value{StringValue("abc"), StringValue("def")}, nil},
+		{"  abc  ,  def  \r\nghi", StringType,
+			[]Value{StringValue("abc"), StringValue("def"), StringValue("ghi")}, nil},
+		{`"a, b" , "c"`, StringType, []Value{StringValue("a, b"), StringValue("c")}, nil},
+		{"12 34,-5\r\n6", IntegerType,
+			[]Value{IntegerValue(12), IntegerValue(34), IntegerValue(-5), IntegerValue(6)}, nil},
+		{"1.5 2.5D3", SingleType, []Value{SingleValue(1.5), SingleValue(2500)}, nil},
+		{"1.5 2.5", IntegerType, []Value{IntegerValue(2), IntegerValue(3)}, nil},
+		{"40000", IntegerType, nil, ErrOverflow},
+		{"abc", IntegerType, nil, ErrTypeMismatch},
+		{"1\n \n", IntegerType, []Value{IntegerValue(1)}, ErrInputPastEnd},
+		{"abc\x1Adef", StringType, []Value{StringValue("abc")}, ErrInputPastEnd},
+	}
+
+	for _, c := range cases {
+		f := &File{Mode: 'I', r: bufio.NewReader(strings.NewReader(c.in))}
+		for _, want := range c.vals {
+			v, err := f.ReadItem(c.typ)
+			if err != nil {
+				t.Errorf("ReadItem(%q) failed with %s", c.in, err)
+			} else if v != want {
+				t.Errorf("ReadItem(%q) got %v want %v", c.in, v, want)
+			}
+		}
+		if c.err != nil {
+			if _, err := f.ReadItem(c.typ); err != c.err {
+				t.Errorf("ReadItem(%q) got error %v want %v", c.in, err, c.err)
+			}
+		}
+	}
+}
+
+func TestBinaryConversions(t *testing.T) {
+	for _, n := range []int{0, 1, -1, 12345, MinInteger, MaxInteger} {
+		s := mki(n)
+		if v, err := cvi(s); len(s) != 2 || err != nil || v != IntegerValue(n) {
+			t.Errorf("cvi(mki(%d)) got %v, %v", n, v, err)
+		}
+	}
+	if _, err := cvi("1"); err != ErrIllegalFunctionCall {
+		t.Errorf("cvi(\"1\") got error %v want %v", err, ErrIllegalFunctionCall)
+	}
+}
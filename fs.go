@@ -0,0 +1,441 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FS is the filesystem that a Basic uses for programs, bytecode, and the files opened by
+// OPEN. It extends fs.FS, which can only read files, with OpenFile, which takes the flags
+// of os.OpenFile and can create and write files, and with Remove and Rename, which work like
+// os.Remove and os.Rename. Directories are listed with fs.ReadDir.
+type FS interface {
+	fs.FS
+	OpenFile(name string, flag int, perm fs.FileMode) (FSFile, error)
+	Remove(name string) error
+	Rename(oldname, newname string) error
+}
+
+// FSFile is a file opened by FS.OpenFile. Random files are read and written at offsets.
+type FSFile interface {
+	fs.File
+	io.Writer
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Create creates or truncates the file name in fsys, the same as os.Create.
+func Create(fsys FS, name string) (FSFile, error) {
+	return fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OSFS is the filesystem of the operating system. If Root is empty, names are paths of the
+// host, the same as for os.Open. Otherwise, names must be valid according to fs.ValidPath and
+// are relative to Root, so they can't use .. to leave it. Like os.DirFS, symbolic links in Root
+// are followed even when they point outside of it, so Root is not a sandbox.
+type OSFS struct {
+	Root string
+}
+
+func (osfs OSFS) path(op, name string) (string, error) {
+	if osfs.Root == "" {
+		return name, nil
+	}
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(osfs.Root, filepath.FromSlash(name)), nil
+}
+
+func (osfs OSFS) Open(name string) (fs.File, error) {
+	return osfs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (osfs OSFS) OpenFile(name string, flag int, perm fs.FileMode) (FSFile, error) {
+	p, err := osfs.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osfs OSFS) Remove(name string) error {
+	p, err := osfs.path("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (osfs OSFS) Rename(oldname, newname string) error {
+	op, err := osfs.path("rename", oldname)
+	if err != nil {
+		return err
+	}
+	np, err := osfs.path("rename", newname)
+	if err != nil {
+		return err
+	}
+	return os.Rename(op, np)
+}
+
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_CREATE | os.O_TRUNC
+
+// ReadOnlyFS makes any fs.FS, for example one from os.DirFS or embed, into an FS where
+// opening a file for writing fails with fs.ErrPermission.
+type ReadOnlyFS struct {
+	fs.FS
+}
+
+// ReadOnlyDir returns an FS for reading the files in directory dir of the host.
+func ReadOnlyDir(dir string) FS {
+	return ReadOnlyFS{os.DirFS(dir)}
+}
+
+func (rofs ReadOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (FSFile, error) {
+	if flag&writeFlags != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	f, err := rofs.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyFile{f, name}, nil
+}
+
+func (rofs ReadOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (rofs ReadOnlyFS) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrPermission}
+}
+
+type readOnlyFile struct {
+	fs.File
+	name string
+}
+
+func (rof readOnlyFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: rof.name, Err: fs.ErrPermission}
+}
+
+func (rof readOnlyFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: rof.name, Err: fs.ErrPermission}
+}
+
+func (rof readOnlyFile) ReadAt(p []byte, off int64) (int, error) {
+	if ra, ok := rof.File.(io.ReaderAt); ok {
+		return ra.ReadAt(p, off)
+	}
+	return 0, &fs.PathError{Op: "read", Path: rof.name, Err: fs.ErrInvalid}
+}
+
+// MemFS is a filesystem which keeps files in memory. Directories are not stored; a directory
+// exists if there are files in it.
+type MemFS struct {
+	mutex sync.Mutex
+	files map[string]*memData
+}
+
+type memData struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: map[string]*memData{},
+	}
+}
+
+func (mfs *MemFS) Open(name string) (fs.File, error) {
+	return mfs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// isDir returns true if there are files in directory name.
+func (mfs *MemFS) isDir(name string) bool {
+	if name == "." {
+		return true
+	}
+	for fn := range mfs.files {
+		if strings.HasPrefix(fn, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (mfs *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (FSFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	mfs.mutex.Lock()
+	defer mfs.mutex.Unlock()
+
+	md, ok := mfs.files[name]
+	if !ok {
+		if mfs.isDir(name) {
+			if flag&writeFlags != 0 {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+			}
+			return &memDir{mfs: mfs, name: name}, nil
+		}
+		if flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if dir := path.Dir(name); dir != "." && mfs.files[dir] != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+		}
+		md = &memData{mode: perm & fs.ModePerm, modTime: time.Now()}
+		mfs.files[name] = md
+	} else if flag&os.O_EXCL != 0 && flag&os.O_CREATE != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	} else if flag&os.O_TRUNC != 0 {
+		md.data = nil
+		md.modTime = time.Now()
+	}
+	return &memFile{mfs: mfs, name: name, md: md, flag: flag}, nil
+}
+
+// Remove removes the file name; directories are removed when they no longer contain files,
+// so removing one is an error.
+func (mfs *MemFS) Remove(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+
+	mfs.mutex.Lock()
+	defer mfs.mutex.Unlock()
+
+	if _, ok := mfs.files[name]; !ok {
+		if mfs.isDir(name) {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+		}
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(mfs.files, name)
+	return nil
+}
+
+// Rename renames the file oldname to newname, replacing newname if it is a file. Directories
+// can not be renamed.
+func (mfs *MemFS) Rename(oldname, newname string) error {
+	if !fs.ValidPath(oldname) || !fs.ValidPath(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+
+	mfs.mutex.Lock()
+	defer mfs.mutex.Unlock()
+
+	md, ok := mfs.files[oldname]
+	if !ok {
+		if mfs.isDir(oldname) {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrInvalid}
+		}
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	if mfs.isDir(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	if dir := path.Dir(newname); dir != "." && mfs.files[dir] != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+	delete(mfs.files, oldname)
+	mfs.files[newname] = md
+	return nil
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (mi memInfo) Name() string       { return mi.name }
+func (mi memInfo) Size() int64        { return mi.size }
+func (mi memInfo) Mode() fs.FileMode  { return mi.mode }
+func (mi memInfo) ModTime() time.Time { return mi.modTime }
+func (mi memInfo) IsDir() bool        { return mi.mode.IsDir() }
+func (mi memInfo) Sys() any           { return nil }
+
+type memFile struct {
+	mfs  *MemFS
+	name string
+	md   *memData
+	flag int
+	off  int64
+}
+
+func (mf *memFile) Stat() (fs.FileInfo, error) {
+	mf.mfs.mutex.Lock()
+	defer mf.mfs.mutex.Unlock()
+
+	return memInfo{path.Base(mf.name), int64(len(mf.md.data)), mf.md.mode, mf.md.modTime}, nil
+}
+
+func (mf *memFile) Read(p []byte) (int, error) {
+	n, err := mf.ReadAt(p, mf.off)
+	mf.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (mf *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if mf.flag&(os.O_WRONLY|os.O_RDWR) == os.O_WRONLY {
+		return 0, &fs.PathError{Op: "read", Path: mf.name, Err: fs.ErrPermission}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: mf.name, Err: fs.ErrInvalid}
+	}
+
+	mf.mfs.mutex.Lock()
+	defer mf.mfs.mutex.Unlock()
+
+	if off >= int64(len(mf.md.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, mf.md.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (mf *memFile) Write(p []byte) (int, error) {
+	if mf.flag&os.O_APPEND != 0 {
+		mf.mfs.mutex.Lock()
+		mf.off = int64(len(mf.md.data))
+		mf.mfs.mutex.Unlock()
+	}
+	n, err := mf.WriteAt(p, mf.off)
+	mf.off += int64(n)
+	return n, err
+}
+
+func (mf *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if mf.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &fs.PathError{Op: "write", Path: mf.name, Err: fs.ErrPermission}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "write", Path: mf.name, Err: fs.ErrInvalid}
+	}
+
+	mf.mfs.mutex.Lock()
+	defer mf.mfs.mutex.Unlock()
+
+	if end := off + int64(len(p)); end > int64(len(mf.md.data)) {
+		mf.md.data = append(mf.md.data, make([]byte, end-int64(len(mf.md.data)))...)
+	}
+	copy(mf.md.data[off:], p)
+	mf.md.modTime = time.Now()
+	return len(p), nil
+}
+
+func (mf *memFile) Close() error {
+	return nil
+}
+
+type memDir struct {
+	mfs     *MemFS
+	name    string
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (md *memDir) Stat() (fs.FileInfo, error) {
+	return memInfo{path.Base(md.name), 0, fs.ModeDir | 0777, time.Time{}}, nil
+}
+
+func (md *memDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: md.name, Err: fs.ErrInvalid}
+}
+
+func (md *memDir) ReadAt(p []byte, off int64) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: md.name, Err: fs.ErrInvalid}
+}
+
+func (md *memDir) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: md.name, Err: fs.ErrInvalid}
+}
+
+func (md *memDir) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: md.name, Err: fs.ErrInvalid}
+}
+
+func (md *memDir) Close() error {
+	return nil
+}
+
+// ReadDir lists the files and directories in the directory the first time it is called;
+// the entries are returned in order by name.
+func (md *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !md.read {
+		md.read = true
+		md.entries = md.mfs.readDir(md.name)
+	}
+
+	if n <= 0 {
+		entries := md.entries
+		md.entries = nil
+		return entries, nil
+	}
+	if len(md.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(md.entries) {
+		n = len(md.entries)
+	}
+	entries := md.entries[:n]
+	md.entries = md.entries[n:]
+	return entries, nil
+}
+
+func (mfs *MemFS) readDir(dir string) []fs.DirEntry {
+	mfs.mutex.Lock()
+	defer mfs.mutex.Unlock()
+
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+	seen := map[string]bool{}
+	var entries []fs.DirEntry
+	for fn, md := range mfs.files {
+		if !strings.HasPrefix(fn, prefix) {
+			continue
+		}
+		name := fn[len(prefix):]
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name = name[:i]
+			if !seen[name] {
+				seen[name] = true
+				entries = append(entries, fs.FileInfoToDirEntry(
+					memInfo{name, 0, fs.ModeDir | 0777, time.Time{}}))
+			}
+		} else {
+			entries = append(entries, fs.FileInfoToDirEntry(
+				memInfo{name, int64(len(md.data)), md.mode, md.modTime}))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
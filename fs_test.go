@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func writeFile(t *testing.T, fsys FS, name, data string) {
+	t.Helper()
+
+	f, err := Create(fsys, name)
+	if err != nil {
+		t.Fatalf("Create(%s) failed with %s", name, err)
+	}
+	if _, err := io.WriteString(f, data); err != nil {
+		t.Fatalf("Write(%s) failed with %s", name, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close(%s) failed with %s", name, err)
+	}
+}
+
+func TestFS(t *testing.T) {
+	cases := []struct {
+		name string
+		fsys FS
+	}{
+		{"MemFS", NewMemFS()},
+		{"OSFS", OSFS{Root: t.TempDir()}},
+	}
+
+	for _, c := range cases {
+		if c.name == "OSFS" {
+			if err := os.Mkdir(c.fsys.(OSFS).Root+"/dir", 0777); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeFile(t, c.fsys, "a.bas", "10 PRINT 1\n")
+		writeFile(t, c.fsys, "dir/b.dat", "abc")
+		if err := fstest.TestFS(c.fsys, "a.bas", "dir/b.dat"); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+
+		f, err := c.fsys.OpenFile("dir/b.dat", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatalf("%s: OpenFile failed with %s", c.name, err)
+		}
+		io.WriteString(f, "def")
+		f.Close()
+		f, err = c.fsys.OpenFile("dir/b.dat", os.O_RDWR, 0)
+		if err != nil {
+			t.Fatalf("%s: OpenFile failed with %s", c.name, err)
+		}
+		f.WriteAt([]byte("X"), 1)
+		f.Close()
+		buf, err := fs.ReadFile(c.fsys, "dir/b.dat")
+		if err != nil {
+			t.Errorf("%s: ReadFile failed with %s", c.name, err)
+		} else if string(buf) != "aXcdef" {
+			t.Errorf("%s: ReadFile got %q want %q", c.name, buf, "aXcdef")
+		}
+
+		if err := c.fsys.Rename("dir/b.dat", "c.dat"); err != nil {
+			t.Errorf("%s: Rename failed with %s", c.name, err)
+		}
+		if err := c.fsys.Remove("c.dat"); err != nil {
+			t.Errorf("%s: Remove failed with %s", c.name, err)
+		}
+		if err := c.fsys.Remove("c.dat"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: Remove(c.dat) got %v want %s", c.name, err, fs.ErrNotExist)
+		}
+
+		_, err = c.fsys.Open("missing.dat")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: Open(missing.dat) got %v want %s", c.name, err, fs.ErrNotExist)
+		}
+		_, err = c.fsys.Open("../a.bas")
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("%s: Open(../a.bas) got %v want %s", c.name, err, fs.ErrInvalid)
+		}
+	}
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	fsys := ReadOnlyFS{fstest.MapFS{
+		"a.bas": &fstest.MapFile{Data: []byte("10 PRINT 1\n")},
+	}}
+	if err := fstest.TestFS(fsys, "a.bas"); err != nil {
+		t.Error(err)
+	}
+
+	flags := []int{
+		os.O_WRONLY,
+		os.O_RDWR,
+		os.O_WRONLY | os.O_APPEND,
+		os.O_RDWR | os.O_CREATE,
+		os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	}
+	for _, flag := range flags {
+		_, err := fsys.OpenFile("a.bas", flag, 0666)
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("OpenFile(%d) got %v want %s", flag, err, fs.ErrPermission)
+		}
+	}
+
+	if err := fsys.Remove("a.bas"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Remove got %v want %s", err, fs.ErrPermission)
+	}
+	if err := fsys.Rename("a.bas", "b.bas"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Rename got %v want %s", err, fs.ErrPermission)
+	}
+
+	f, err := fsys.OpenFile("a.bas", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed with %s", err)
+	}
+	if _, err := f.Write([]byte("x")); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Write got %v want %s", err, fs.ErrPermission)
+	}
+	f.Close()
+}
+
+func TestFSOpen(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{`
+open "o", 1, "test.dat"
+print #1, "hello"
+close
+open "i", 1, "test.dat"
+line input #1, a$
+print a$
+`, "hello\n"},
+		{`open "o", 1, "test.dat"`, "basic: error: Permission denied\n"},
+		{`open "a", 1, "test.dat"`, "basic: error: Permission denied\n"},
+		{`open "r", 1, "test.dat"`, "basic: error: Permission denied\n"},
+		{`save "test.bas"`, "basic: error: SAVE: open test.bas: permission denied\n"},
+		{`open "i", 1, "../test.dat"`, "basic: error: Bad file name\n"},
+	}
+
+	mfs := NewMemFS()
+	for i, c := range cases {
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		if i == 0 {
+			b.FS = mfs
+		} else {
+			b.FS = ReadOnlyFS{mfs}
+		}
+		b.Program(&TokenReader{
+			R: bufio.NewReader(bytes.NewBufferString(c.in + "\n")),
+		})
+		if out := w.String(); out != c.out {
+			t.Errorf("program:\n%sgot:\n%swant:\n%s", c.in, out, c.out)
+		}
+	}
+}
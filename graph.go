@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// reach returns which lines control can get to from line start by falling through and
+// following GOTOs; GOSUBs are assumed to come back to the next line. The result has an extra
+// element for the end of the program.
+func (p program) reach(start int) []bool {
+	reached := make([]bool, len(p.lines)+1)
+	todo := []int{start}
+	for len(todo) > 0 {
+		i := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if reached[i] {
+			continue
+		}
+		reached[i] = true
+		if i == len(p.lines) {
+			continue
+		}
+
+		f := p.flows[i]
+		for _, ref := range f.Gotos {
+			todo = append(todo, p.target(ref.Number))
+		}
+		if f.Next {
+			todo = append(todo, i+1)
+		}
+	}
+	return reached
+}
+
+// block is a basic block: the lines from First up to but not including Last, which only
+// start at First and only branch at Last-1.
+type block struct {
+	First, Last int
+}
+
+type graphEdge struct {
+	From, To string
+	Label    string
+}
+
+type grapher struct {
+	program
+	blocks  []block
+	blockOf []int
+	edges   []graphEdge
+	seen    map[graphEdge]bool
+}
+
+// splitBlocks splits the lines into basic blocks. A block starts at the first line, at any line
+// which is jumped to, and after any line which can jump, END, or RETURN.
+func (g *grapher) splitBlocks() {
+	leader := make([]bool, len(g.lines)+1)
+	leader[0] = true
+	for i, f := range g.flows {
+		for _, ref := range append(f.Gotos, f.GoSubs...) {
+			leader[g.target(ref.Number)] = true
+		}
+		if len(f.Gotos) > 0 || len(f.GoSubs) > 0 || f.End || f.Return || !f.Next {
+			leader[i+1] = true
+		}
+	}
+
+	g.blockOf = make([]int, len(g.lines))
+	for i := range g.lines {
+		if leader[i] {
+			g.blocks = append(g.blocks, block{First: i})
+		}
+		g.blockOf[i] = len(g.blocks) - 1
+		g.blocks[len(g.blocks)-1].Last = i + 1
+	}
+}
+
+// node returns the name of the node for line i, which is the block containing it, or the end
+// of the program.
+func (g *grapher) node(i int) string {
+	if i == len(g.lines) {
+		return "end"
+	}
+	return fmt.Sprintf("L%d", g.lines[g.blocks[g.blockOf[i]].First].Number)
+}
+
+func (g *grapher) edge(from, to, label string) {
+	e := graphEdge{from, to, label}
+	if !g.seen[e] {
+		g.seen[e] = true
+		g.edges = append(g.edges, e)
+	}
+}
+
+// dotQuote quotes s as a DOT string; lines ending in \l are left justified.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\l`) + `"`
+}
+
+// Graph writes the control-flow graph of the program to w in the DOT language of Graphviz.
+// The nodes are basic blocks of lines, and the edges are falling through to the next line,
+// GOTO, IF GOTO, THEN and ELSE, GOSUB, and RETURN. The blocks which are only reached by a
+// GOSUB are grouped into a cluster for each subroutine.
+func (b *Basic) Graph(w io.Writer) {
+	g := grapher{program: b.program(), seen: map[graphEdge]bool{}}
+	g.splitBlocks()
+
+	g.edge("start", g.node(0), "")
+	type call struct {
+		sub, ret int
+	}
+	var calls []call
+	for _, blk := range g.blocks {
+		i := blk.Last - 1
+		f := g.flows[i]
+		from := g.node(i)
+		for _, ref := range f.Gotos {
+			g.edge(from, g.node(g.target(ref.Number)), ref.Keyword)
+		}
+		for _, ref := range f.GoSubs {
+			sub := g.target(ref.Number)
+			g.edge(from, g.node(sub), ref.Keyword)
+			calls = append(calls, call{sub, i + 1})
+		}
+		if f.Next {
+			g.edge(from, g.node(i+1), "")
+		}
+		if f.End {
+			g.edge(from, "end", "")
+		}
+	}
+
+	// Each block belongs to the main program (-1) if it can be reached without a GOSUB, or
+	// if nothing reaches it. Otherwise, it belongs to the subroutine which starts with it, or
+	// to the first subroutine which reaches it.
+	cluster := make([]int, len(g.blocks))
+	for i := range cluster {
+		cluster[i] = -1
+	}
+	inMain := g.reach(0)
+	subs := map[int][]int{}
+	for _, c := range calls {
+		subs[c.sub] = append(subs[c.sub], c.ret)
+	}
+	var starts []int
+	for s := range subs {
+		if s < len(g.lines) {
+			starts = append(starts, s)
+			if !inMain[s] {
+				cluster[g.blockOf[s]] = s
+			}
+		}
+	}
+	sort.Ints(starts)
+	for _, s := range starts {
+		reached := g.reach(s)
+		for i, blk := range g.blocks {
+			if !reached[blk.First] {
+				continue
+			}
+			if cluster[i] == -1 && !inMain[blk.First] {
+				cluster[i] = s
+			}
+			last := blk.Last - 1
+			if g.flows[last].Return {
+				for _, ret := range subs[s] {
+					g.edge(g.node(last), g.node(ret), "RETURN")
+				}
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	fmt.Fprintln(bw, "digraph program {")
+	fmt.Fprintln(bw, "\tnode [shape=box, fontname=monospace];")
+	fmt.Fprintln(bw, "\tstart [shape=oval, label=\"START\"];")
+	fmt.Fprintln(bw, "\tend [shape=oval, label=\"END\"];")
+	writeNode := func(indent string, i int) {
+		var sb strings.Builder
+		for _, line := range g.lines[g.blocks[i].First:g.blocks[i].Last] {
+			fmt.Fprintf(&sb, "%d ", line.Number)
+			line.Stmt.Print(&sb)
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "%s%s [label=%s];\n", indent, g.node(g.blocks[i].First),
+			dotQuote(sb.String()))
+	}
+	for i := range g.blocks {
+		if cluster[i] == -1 {
+			writeNode("\t", i)
+		}
+	}
+	for _, s := range starts {
+		if cluster[g.blockOf[s]] != s {
+			continue
+		}
+		fmt.Fprintf(bw, "\tsubgraph cluster_%d {\n", g.lines[s].Number)
+		fmt.Fprintf(bw, "\t\tlabel=\"GOSUB %d\";\n", g.lines[s].Number)
+		for i := range g.blocks {
+			if cluster[i] == s {
+				writeNode("\t\t", i)
+			}
+		}
+		fmt.Fprintln(bw, "\t}")
+	}
+	for _, e := range g.edges {
+		if e.Label == "" {
+			fmt.Fprintf(bw, "\t%s -> %s;\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(bw, "\t%s -> %s [label=%s];\n", e.From, e.To, dotQuote(e.Label))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+}
+
+// SaveGraph writes the control-flow graph of the program to the file fn.
+func (b *Basic) SaveGraph(fn string) {
+	f, err := Create(b.FS, fn)
+	if err != nil {
+		fmt.Fprintf(b.ErrW, "basic: error: GRAPH: %s\n", err)
+		return
+	}
+	defer f.Close()
+
+	b.Graph(f)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+)
+
+func TestGraph(t *testing.T) {
+	cases := []struct {
+		src string
+		dot string
+	}{
+		{"", `digraph program {
+	node [shape=box, fontname=monospace];
+	start [shape=oval, label="START"];
+	end [shape=oval, label="END"];
+	start -> end;
+}
+`},
+		{`10 A = 1
+20 IF A = 1 THEN GOTO 50 ELSE PRINT "no"
+30 GOSUB 100
+40 END
+50 PRINT "yes"
+60 GOTO 30
+100 IF A > 0 THEN RETURN
+110 A = A + 1
+120 RETURN
+`, `digraph program {
+	node [shape=box, fontname=monospace];
+	start [shape=oval, label="START"];
+	end [shape=oval, label="END"];
+	L10 [label="10 A = 1\l20 IF A = 1 THEN GOTO 50 ELSE PRINT \"no\"\l"];
+	L30 [label="30 GOSUB 100\l"];
+	L40 [label="40 END\l"];
+	L50 [label="50 PRINT \"yes\"\l60 GOTO 30\l"];
+	subgraph cluster_100 {
+		label="GOSUB 100";
+		L100 [label="100 IF A > 0 THEN RETURN\l"];
+		L110 [label="110 A = A + 1\l120 RETURN\l"];
+	}
+	start -> L10;
+	L10 -> L50 [label="GOTO"];
+	L10 -> L30;
+	L30 -> L100 [label="GOSUB"];
+	L30 -> L40;
+	L40 -> end;
+	L50 -> L30 [label="GOTO"];
+	L100 -> L110;
+	L100 -> L40 [label="RETURN"];
+	L110 -> L40 [label="RETURN"];
+}
+`},
+	}
+
+	for _, c := range cases {
+		fsys := NewMemFS()
+		writeFile(t, fsys, "t.bas", c.src)
+
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		b.FS = fsys
+		if !b.Load("t.bas") {
+			t.Fatalf("Load(%q) failed: %s", c.src, w.String())
+		}
+		b.Graph(w)
+		if out := w.String(); out != c.dot {
+			t.Errorf("Graph(%q) got:\n%swant:\n%s", c.src, out, c.dot)
+		}
+
+		b.SaveGraph("t.dot")
+		buf, err := fs.ReadFile(fsys, "t.dot")
+		if err != nil {
+			t.Errorf("SaveGraph failed with %s", err)
+		} else if string(buf) != c.dot {
+			t.Errorf("SaveGraph(%q) got:\n%swant:\n%s", c.src, buf, c.dot)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+)
+
+// A bytecode file starts with imageMagic followed by imageVersion, and then the names,
+// constants, line table, and code of an Image. Integers are written as varints and strings
+// as a length followed by the bytes.
+const (
+	imageMagic   = "\x00BBC"
+	imageVersion = 11
+)
+
+var constTags = [NumTypes]byte{
+	IntegerType: 'i',
+	SingleType:  'f',
+	DoubleType:  'd',
+	StringType:  's',
+	BooleanType: 'b',
+}
+
+type imageWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (iw *imageWriter) int(n int64) {
+	iw.w.Write(iw.buf[:binary.PutVarint(iw.buf[:], n)])
+}
+
+func (iw *imageWriter) string(s string) {
+	iw.int(int64(len(s)))
+	iw.w.WriteString(s)
+}
+
+func WriteImage(w io.Writer, img *Image) error {
+	iw := &imageWriter{w: bufio.NewWriter(w)}
+	iw.w.WriteString(imageMagic)
+	iw.int(imageVersion)
+
+	iw.int(int64(len(img.Names)))
+	for _, name := range img.Names {
+		iw.string(name)
+	}
+
+	iw.int(int64(len(img.Consts)))
+	for _, val := range img.Consts {
+		iw.w.WriteByte(constTags[val.Type])
+		switch val.Type {
+		case IntegerType, BooleanType:
+			iw.int(int64(val.Integer))
+		case SingleType, DoubleType:
+			binary.LittleEndian.PutUint64(iw.buf[:], math.Float64bits(val.Float))
+			iw.w.Write(iw.buf[:8])
+		case StringType:
+			iw.string(val.String)
+		default:
+			panic("unexpected value type")
+		}
+	}
+
+	iw.int(int64(len(img.Lines)))
+	for _, li := range img.Lines {
+		iw.int(int64(li.Number))
+		iw.int(int64(li.PC))
+	}
+
+	iw.int(int64(len(img.Code)))
+	for _, n := range img.Code {
+		iw.int(int64(n))
+	}
+
+	return iw.w.Flush()
+}
+
+var errBadImage = errors.New("bad bytecode file")
+
+const maxCount = 1<<31 - 1
+
+type imageReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (ir *imageReader) int() int64 {
+	if ir.err != nil {
+		return 0
+	}
+	n, err := binary.ReadVarint(ir.r)
+	if err != nil {
+		ir.err = errBadImage
+	}
+	return n
+}
+
+func (ir *imageReader) count() int {
+	n := ir.int()
+	if n < 0 || n > maxCount {
+		ir.err = errBadImage
+		return 0
+	}
+	return int(n)
+}
+
+func (ir *imageReader) string() string {
+	n := ir.count()
+	if ir.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(ir.r, buf); err != nil {
+		ir.err = errBadImage
+	}
+	return string(buf)
+}
+
+// IsImage returns true if buf starts with the magic number of a bytecode file.
+func IsImage(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte(imageMagic))
+}
+
+// IsImageFile returns true if the file fn is a bytecode file rather than program source.
+func IsImageFile(fsys fs.FS, fn string) bool {
+	f, err := fsys.Open(fn)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(imageMagic))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false
+	}
+	return IsImage(buf)
+}
+
+func ReadImage(r io.Reader) (*Image, error) {
+	ir := &imageReader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(imageMagic))
+	if _, err := io.ReadFull(ir.r, magic); err != nil || !IsImage(magic) {
+		return nil, errBadImage
+	}
+	if v := ir.int(); ir.err == nil && v != imageVersion {
+		return nil, fmt.Errorf("unsupported bytecode version: %d", v)
+	}
+
+	img := &Image{}
+	img.Names = make([]string, ir.count())
+	for i := range img.Names {
+		img.Names[i] = ir.string()
+	}
+
+	img.Consts = make([]Value, ir.count())
+	for i := range img.Consts {
+		if ir.err != nil {
+			break
+		}
+		tag, err := ir.r.ReadByte()
+		if err != nil {
+			return nil, errBadImage
+		}
+		switch tag {
+		case constTags[IntegerType]:
+			img.Consts[i] = IntegerValue(int(ir.int()))
+		case constTags[SingleType], constTags[DoubleType]:
+			var buf [8]byte
+			if _, err := io.ReadFull(ir.r, buf[:]); err != nil {
+				return nil, errBadImage
+			}
+			img.Consts[i] = DoubleValue(math.Float64frombits(binary.LittleEndian.Uint64(buf[:])))
+			if tag == constTags[SingleType] {
+				img.Consts[i].Type = SingleType
+			}
+		case constTags[StringType]:
+			img.Consts[i] = StringValue(ir.string())
+		case constTags[BooleanType]:
+			img.Consts[i] = BooleanValue(ir.int() != 0)
+		default:
+			return nil, errBadImage
+		}
+	}
+
+	img.Lines = make([]LineInfo, ir.count())
+	for i := range img.Lines {
+		img.Lines[i].Number = int(ir.int())
+		img.Lines[i].PC = int(ir.int())
+	}
+
+	img.Code = make([]int32, ir.count())
+	for i := range img.Code {
+		img.Code[i] = int32(ir.int())
+	}
+
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	if !img.valid() {
+		return nil, errBadImage
+	}
+	return img, nil
+}
+
+// valid checks that the code of an image read from a file can be executed without going
+// out of bounds: operands must be in range, jumps must land on an instruction, and the stack
+// must be empty at the start of every line and at every jump.
+func (img *Image) valid() bool {
+	for _, name := range img.Names {
+		if name == "" || VarType(name) == NoType {
+			return false
+		}
+	}
+
+	code := img.Code
+	if len(code) == 0 || Opcode(code[len(code)-1]) != OpEnd {
+		return false
+	}
+
+	depths := make([]int, len(code))
+	depth := 0
+	for pc := 0; pc < len(code); {
+		op := Opcode(code[pc])
+		if op < 0 || op >= NumOpcodes || pc+opcodeArgs[op] >= len(code) {
+			return false
+		}
+		depths[pc] = depth + 1
+
+		pops, pushes := opcodeStack[op][0], opcodeStack[op][1]
+		if op == OpPrintUsing {
+			if code[pc+1] < 0 {
+				return false
+			}
+			pops += int(code[pc+1])
+		} else if op == OpGet || op == OpPut {
+			if code[pc+1] != 0 && code[pc+1] != 1 {
+				return false
+			}
+			pops += int(code[pc+1])
+		}
+		if depth < pops {
+			return false
+		}
+		depth += pushes - pops
+
+		switch op {
+		case OpEnd, OpExit, OpJump, OpJumpFalse, OpGoSub, OpReturn:
+			if depth != 0 {
+				return false
+			}
+		}
+		if op == OpEnd || op == OpExit || op == OpJump || op == OpReturn {
+			depth = 0
+		}
+		pc += 1 + opcodeArgs[op]
+	}
+
+	for pc := 0; pc < len(code); pc += 1 + opcodeArgs[Opcode(code[pc])] {
+		op := Opcode(code[pc])
+		if opcodeArgs[op] == 0 {
+			continue
+		}
+		arg := int(code[pc+1])
+		switch op {
+		case OpConst:
+			if arg < 0 || arg >= len(img.Consts) {
+				return false
+			}
+		case OpLoad, OpStore:
+			if arg < 0 || arg >= len(img.Names) {
+				return false
+			}
+		case OpField, OpLSet, OpRSet:
+			if arg < 0 || arg >= len(img.Names) || VarType(img.Names[arg]) != StringType {
+				return false
+			}
+		case OpReadItem:
+			if t := Type(arg); !t.Numeric() && t != StringType {
+				return false
+			}
+		case OpExit:
+			if arg != 0 && arg != 1 {
+				return false
+			}
+		case OpJump, OpJumpFalse, OpGoSub:
+			if arg < 0 || arg >= len(code) || depths[arg] != 1 {
+				return false
+			}
+		}
+	}
+
+	for _, li := range img.Lines {
+		if li.PC < 0 || li.PC >= len(code) || depths[li.PC] != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Basic) SaveImage(fn string, img *Image) bool {
+	f, err := Create(b.FS, fn)
+	if err != nil {
+		fmt.Fprintf(b.ErrW, "basic: error: SAVE: %s\n", err)
+		return false
+	}
+	defer f.Close()
+
+	err = WriteImage(f, img)
+	if err != nil {
+		fmt.Fprintf(b.ErrW, "basic: error: SAVE: %s\n", err)
+		return false
+	}
+	return true
+}
+
+func (b *Basic) LoadImage(fn string) (*Image, bool) {
+	f, err := b.FS.Open(fn)
+	if err != nil {
+		fmt.Fprintf(b.ErrW, "basic: error: OPEN: %s\n", err)
+		return nil, false
+	}
+	defer f.Close()
+
+	img, err := ReadImage(f)
+	if err != nil {
+		fmt.Fprintf(b.ErrW, "basic: error: %s: %s\n", fn, err)
+		return nil, false
+	}
+	b.New()
+	b.SetImage(img)
+	return img, true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestImage(t *testing.T) {
+	cases := []struct {
+		prog, out string
+	}{
+		{`
+10 abc% = 123
+20 abc$ = "def"
+25 gosub 50
+30 print abc%
+40 print abc$
+45 end
+50 print abc%, abc$
+55 return
+60 print "never ran"
+`, " 123          def\n 123 \ndef\n"},
+		{`
+10 abc% = 123
+20 if abc% <> 123 then goto 40 else goto 60
+30 print 456
+40 print 234
+50 end
+60 print abc% = 123, "abc" < "def"
+`, "TRUE          TRUE\n"},
+		{`
+10 i% = 0
+20 i% = i% + 1
+30 if i% < 10 goto 20
+40 print i% + 9
+`, " 19 \n"},
+		{`
+10 print "a"
+20 if 1 = 1 then end 3
+30 system
+`, "a\n"},
+	}
+
+	for _, c := range cases {
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		b.Program(&TokenReader{
+			R: bufio.NewReader(bytes.NewBufferString(c.prog)),
+		})
+
+		img, ok := b.Compile()
+		if !ok {
+			t.Errorf("Compile(%s) failed", c.prog)
+			continue
+		}
+		buf := &bytes.Buffer{}
+		err := WriteImage(buf, img)
+		if err != nil {
+			t.Errorf("WriteImage(%s) failed with %s", c.prog, err)
+			continue
+		}
+		if !IsImage(buf.Bytes()) {
+			t.Errorf("IsImage(%s) returned false", c.prog)
+		}
+
+		img, err = ReadImage(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("ReadImage(%s) failed with %s", c.prog, err)
+			continue
+		}
+
+		w.Reset()
+		b = NewBasic(w, w)
+		b.SetImage(img)
+		b.Execute(img)
+		if w.String() != c.out {
+			t.Errorf("program:\n%sgot:\n%swant:\n%s", c.prog, w.String(), c.out)
+		}
+
+		for n := 0; n < buf.Len(); n += 1 {
+			trunc := buf.Bytes()[:n]
+			if _, err := ReadImage(bytes.NewReader(trunc)); err == nil {
+				t.Errorf("ReadImage(%s) truncated to %d bytes did not fail", c.prog, n)
+			}
+		}
+	}
+}
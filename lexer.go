@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type Token int
+
+const (
+	EndOfLine Token = iota
+	KeywordToken
+	IntegerToken
+	FloatToken
+	StringToken
+	OperatorToken
+	ErrorToken
+)
+
+// Pos is the position of a character or token in the source: both the line and the column
+// count from one, and the column counts characters, not bytes.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+// Lexeme is a token read by a TokenReader.
+//
+// KeywordToken: S is a keyword or a variable name, in upper case and including any type
+// suffix ($, %, !, or #).
+// IntegerToken: N is the value of a number written without a decimal point, exponent, or
+// suffix other than %, or of a &H or &O constant; S is the number as written, in upper case.
+// FloatToken: S is the number, in upper case and including any exponent and suffix.
+// StringToken: S is the string without the quotes.
+// OperatorToken: S is the operator; =< and => are returned as <= and >=, and >< as <>.
+// EndOfLine: S is the comment, starting with ', if the line ends with one.
+// ErrorToken: S is the BASIC-80 error message for the malformed token.
+type Lexeme struct {
+	Token Token
+	Pos   Pos
+	N     int
+	S     string
+}
+
+// Keywords are the reserved words of BASIC-80 which this interpreter knows about; variable
+// names may not be one of them. The names of the Functions are added by init.
+var Keywords = map[string]bool{
+	"APPEND": true,
+	"AS":     true,
+	"ASSERT": true,
+	"CLOSE":  true,
+	"DEFDBL": true,
+	"DEFINT": true,
+	"DEFSNG": true,
+	"DEFSTR": true,
+	"DELETE": true,
+	"ELSE":   true,
+	"END":    true,
+	"EXIT":   true,
+	"FIELD":  true,
+	"FILES":  true,
+	"FOR":    true,
+	"GET":    true,
+	"GOSUB":  true,
+	"GOTO":   true,
+	"HELP":   true,
+	"IF":     true,
+	"INPUT":  true,
+	"KILL":   true,
+	"LET":    true,
+	"LINE":   true,
+	"LIST":   true,
+	"LOAD":   true,
+	"LSET":   true,
+	"MERGE":  true,
+	"NAME":   true,
+	"NEW":    true,
+	"NEXT":   true,
+	"OPEN":   true,
+	"OUTPUT": true,
+	"PRINT":  true,
+	"PUT":    true,
+	"REM":    true,
+	"RETURN": true,
+	"RSET":   true,
+	"RUN":    true,
+	"SAVE":   true,
+	"SPC":    true,
+	"STEP":   true,
+	"SYSTEM": true,
+	"TAB":    true,
+	"THEN":   true,
+	"TO":     true,
+	"USING":  true,
+	"WEND":   true,
+	"WHILE":  true,
+	"WIDTH":  true,
+	"WRITE":  true,
+}
+
+func init() {
+	for name := range Functions {
+		Keywords[name] = true
+	}
+}
+
+// TokenReader reads the tokens of a program or of commands. Source files from other systems
+// are accepted: lines can end with CR LF, LF, or CR; a UTF-8 byte order mark at the start is
+// skipped; Ctrl-Z (CP/M) marks the end of the file; and a missing newline at the end of the
+// last line is supplied. Err is set if reading fails with an error other than io.EOF.
+//
+// At the end of the input, ReadToken returns EndOfLine; use SkipBlankLines to check for the
+// end of the input before reading the tokens of a line.
+//
+// If Crunched is set, keywords do not need spaces around them, as in IFA>5THENPRINTA+B, which
+// is how many published listings were written. As in MBASIC, a keyword is found wherever it
+// starts inside of a run of letters and digits, so it ends any name before it: TOTAL is TO
+// followed by TAL. Long variable names which contain keywords need Crunched to be off.
+type TokenReader struct {
+	R        *bufio.Reader
+	Crunched bool
+	AtEOL    bool
+	Err      error
+	Pos      Pos // the position of the last token read
+	peeked   bool
+	lx       Lexeme
+
+	started bool // the byte order mark has been checked for
+	eof     bool
+	midLine bool // characters have been read since the last newline
+	line    int
+	column  int
+	last    char   // the last character read, returned again after UnreadChar
+	back    []char // characters which have been pushed back
+}
+
+type char struct {
+	ch  rune // '\n' at the end of the input
+	eof bool
+	pos Pos
+}
+
+func (tr *TokenReader) readChar() char {
+	if tr.eof {
+		return char{'\n', true, Pos{tr.line, tr.column + 1}}
+	}
+
+	ch, _, err := tr.R.ReadRune()
+	if !tr.started {
+		tr.started = true
+		tr.line = 1
+		if err == nil && ch == '\uFEFF' {
+			ch, _, err = tr.R.ReadRune()
+		}
+	}
+	if err == nil && ch == ctrlZ {
+		err = io.EOF
+	}
+	pos := Pos{tr.line, tr.column + 1}
+	if err != nil {
+		if err != io.EOF {
+			tr.Err = err
+		}
+		tr.eof = true
+		if tr.midLine {
+			tr.midLine = false
+			tr.line += 1
+			tr.column = 0
+			return char{'\n', false, pos}
+		}
+		return char{'\n', true, pos}
+	}
+
+	if ch == '\r' {
+		if next, _, err := tr.R.ReadRune(); err == nil && next != '\n' {
+			tr.R.UnreadRune()
+		}
+		ch = '\n'
+	}
+	tr.midLine = ch != '\n'
+	if ch == '\n' {
+		tr.line += 1
+		tr.column = 0
+	} else {
+		tr.column += 1
+	}
+	return char{ch, false, pos}
+}
+
+func (tr *TokenReader) next() char {
+	if len(tr.back) > 0 {
+		tr.last = tr.back[len(tr.back)-1]
+		tr.back = tr.back[:len(tr.back)-1]
+	} else {
+		tr.last = tr.readChar()
+	}
+	return tr.last
+}
+
+func (tr *TokenReader) backup(c char) {
+	tr.back = append(tr.back, c)
+}
+
+// ReadCharEOF returns the next character, with the end of every line as '\n', or true at
+// the end of the input.
+func (tr *TokenReader) ReadCharEOF() (rune, bool) {
+	c := tr.next()
+	if c.eof {
+		return 0, true
+	}
+	return c.ch, false
+}
+
+// ReadChar returns the next character; at the end of the input, it returns '\n'.
+func (tr *TokenReader) ReadChar() rune {
+	return tr.next().ch
+}
+
+// UnreadChar makes the last character read be returned again.
+func (tr *TokenReader) UnreadChar() {
+	tr.backup(tr.last)
+}
+
+func isSpace(ch rune) bool {
+	return ch == ' ' || ch == '\t'
+}
+
+func isLetter(ch rune) bool {
+	return (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z')
+}
+
+func isDigit(ch rune) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// SkipBlankLines skips spaces, tabs, and empty lines; it returns false at the end of the
+// input.
+func (tr *TokenReader) SkipBlankLines() bool {
+	for {
+		c := tr.next()
+		if c.eof {
+			return false
+		}
+		if !isSpace(c.ch) && c.ch != '\n' {
+			tr.backup(c)
+			return true
+		}
+	}
+}
+
+// Next returns the next token.
+func (tr *TokenReader) Next() Lexeme {
+	if tr.peeked {
+		tr.peeked = false
+	} else {
+		tr.lx = tr.lex()
+	}
+	tr.Pos = tr.lx.Pos
+	return tr.lx
+}
+
+// Peek returns the next token without reading it.
+func (tr *TokenReader) Peek() Lexeme {
+	if !tr.peeked {
+		tr.lx = tr.lex()
+		tr.peeked = true
+	}
+	return tr.lx
+}
+
+func (tr *TokenReader) ReadToken() (Token, int, string) {
+	lx := tr.Next()
+	return lx.Token, lx.N, lx.S
+}
+
+func (tr *TokenReader) PeekToken() (Token, int, string) {
+	lx := tr.Peek()
+	return lx.Token, lx.N, lx.S
+}
+
+func (tr *TokenReader) lex() Lexeme {
+	tr.AtEOL = false
+
+	c := tr.next()
+	for isSpace(c.ch) {
+		c = tr.next()
+	}
+
+	switch {
+	case c.ch == '\n':
+		tr.AtEOL = true
+		return Lexeme{Token: EndOfLine, Pos: c.pos}
+	case c.ch == '\'':
+		pos := c.pos
+		var sb strings.Builder
+		for c.ch != '\n' {
+			sb.WriteRune(c.ch)
+			c = tr.next()
+		}
+		tr.AtEOL = true
+		return Lexeme{Token: EndOfLine, Pos: pos, S: sb.String()}
+	case isLetter(c.ch):
+		return tr.lexWord(c)
+	case isDigit(c.ch) || c.ch == '.':
+		return tr.lexNumber(c)
+	case c.ch == '&':
+		return tr.lexRadix(c)
+	case c.ch == '"':
+		return tr.lexString(c)
+	case c.ch == '?':
+		return Lexeme{Token: KeywordToken, Pos: c.pos, S: "PRINT"}
+	case c.ch == '<' || c.ch == '>' || c.ch == '=':
+		return tr.lexRelation(c)
+	case strings.ContainsRune("+-*/\\^(),;:#", c.ch):
+		return Lexeme{Token: OperatorToken, Pos: c.pos, S: string(c.ch)}
+	}
+	return Lexeme{Token: ErrorToken, Pos: c.pos, S: "Syntax error"}
+}
+
+// lexWord reads a keyword or a variable name: a letter followed by letters, digits, and
+// periods, and then an optional type suffix. Keywords only take a $ suffix, so that
+// PRINT#1 is PRINT followed by #.
+func (tr *TokenReader) lexWord(c char) Lexeme {
+	pos := c.pos
+	var run []char
+	for isLetter(c.ch) || isDigit(c.ch) || c.ch == '.' {
+		run = append(run, c)
+		c = tr.next()
+	}
+	if tr.Crunched {
+		return tr.crunchedWord(pos, run, c)
+	}
+
+	word := upperWord(run)
+	if c.ch == '$' || c.ch == '%' || ((c.ch == '!' || c.ch == '#') && !Keywords[word]) {
+		word += string(c.ch)
+	} else {
+		tr.backup(c)
+	}
+	return Lexeme{Token: KeywordToken, Pos: pos, S: word}
+}
+
+func upperWord(run []char) string {
+	var sb strings.Builder
+	for _, c := range run {
+		sb.WriteRune(c.ch)
+	}
+	return strings.ToUpper(sb.String())
+}
+
+// maxKeyword is the length of the longest keyword.
+var maxKeyword int
+
+func init() {
+	for kw := range Keywords {
+		if len(kw) > maxKeyword {
+			maxKeyword = len(kw)
+		}
+	}
+}
+
+// keywordPrefix returns the length of the longest keyword at the start of word, or zero.
+func keywordPrefix(word string) int {
+	for n := min(len(word), maxKeyword); n > 0; n -= 1 {
+		if Keywords[word[:n]] {
+			return n
+		}
+	}
+	return 0
+}
+
+// crunchedWord splits the run of letters, digits, and periods in run, followed by c, at
+// the first keyword in it. A keyword at the start is returned; otherwise the name before
+// the keyword is returned. The rest of run is read again by the following tokens.
+func (tr *TokenReader) crunchedWord(pos Pos, run []char, c char) Lexeme {
+	word := upperWord(run)
+	if c.ch == '$' {
+		word += "$"
+	}
+
+	for i := 0; i < len(run); i += 1 {
+		if !isLetter(run[i].ch) {
+			continue
+		}
+		n := keywordPrefix(word[i:])
+		if n == 0 {
+			continue
+		}
+		if i > 0 {
+			n = 0
+		}
+		if i+n > len(run) {
+			// The keyword includes the $ in c, for example MKI$.
+			return Lexeme{Token: KeywordToken, Pos: pos, S: word}
+		}
+		tr.backup(c)
+		for j := len(run) - 1; j >= i+n; j -= 1 {
+			tr.backup(run[j])
+		}
+		return Lexeme{Token: KeywordToken, Pos: pos, S: word[:i+n]}
+	}
+
+	word = upperWord(run)
+	if c.ch == '$' || c.ch == '%' || c.ch == '!' || c.ch == '#' {
+		word += string(c.ch)
+	} else {
+		tr.backup(c)
+	}
+	return Lexeme{Token: KeywordToken, Pos: pos, S: word}
+}
+
+// ReadCommand reads the next word, before any other token on the line has been read, if it is
+// one of commands and it is not a variable: it is followed by a space or by the end of the
+// line, even when Crunched is set. Otherwise, nothing is read.
+func (tr *TokenReader) ReadCommand(commands map[string]bool) (string, bool) {
+	if tr.peeked {
+		return "", false
+	}
+	var run []char
+	c := tr.next()
+	for isLetter(c.ch) {
+		run = append(run, c)
+		c = tr.next()
+	}
+	tr.backup(c)
+	if word := upperWord(run); commands[word] && (isSpace(c.ch) || c.ch == '\n') {
+		tr.Pos = run[0].pos
+		return word, true
+	}
+	for j := len(run) - 1; j >= 0; j -= 1 {
+		tr.backup(run[j])
+	}
+	return "", false
+}
+
+// maxIntegerDigits is the most digits that an IntegerToken can have; numbers with more
+// digits are returned as a FloatToken.
+const maxIntegerDigits = 9
+
+// lexNumber reads a number: digits with an optional decimal point, an optional exponent
+// starting with E (single precision) or D (double precision), and an optional type suffix.
+func (tr *TokenReader) lexNumber(c char) Lexeme {
+	pos := c.pos
+	var sb strings.Builder
+	integer := true
+	for isDigit(c.ch) {
+		sb.WriteRune(c.ch)
+		c = tr.next()
+	}
+	if c.ch == '.' {
+		integer = false
+		sb.WriteRune(c.ch)
+		c = tr.next()
+		for isDigit(c.ch) {
+			sb.WriteRune(c.ch)
+			c = tr.next()
+		}
+	}
+
+	if c.ch == 'E' || c.ch == 'e' || c.ch == 'D' || c.ch == 'd' {
+		exp := c
+		c = tr.next()
+		if c.ch == '+' || c.ch == '-' {
+			sign := c
+			c = tr.next()
+			if isDigit(c.ch) {
+				sb.WriteRune(exp.ch)
+				sb.WriteRune(sign.ch)
+			} else {
+				tr.backup(c)
+				tr.backup(sign)
+				c = exp
+			}
+		} else if isDigit(c.ch) {
+			sb.WriteRune(exp.ch)
+		} else {
+			tr.backup(c)
+			c = exp
+		}
+		if c != exp {
+			integer = false
+			for isDigit(c.ch) {
+				sb.WriteRune(c.ch)
+				c = tr.next()
+			}
+		}
+	}
+
+	num := strings.ToUpper(sb.String())
+	switch c.ch {
+	case '%':
+		if !integer {
+			return Lexeme{Token: ErrorToken, Pos: pos, S: "Syntax error"}
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil || n > MaxInteger {
+			return Lexeme{Token: ErrorToken, Pos: pos, S: "Overflow"}
+		}
+		return Lexeme{Token: IntegerToken, Pos: pos, N: n, S: num + "%"}
+	case '!', '#':
+		return Lexeme{Token: FloatToken, Pos: pos, S: num + string(c.ch)}
+	}
+	tr.backup(c)
+
+	if integer && len(num) <= maxIntegerDigits {
+		n, _ := strconv.Atoi(num)
+		return Lexeme{Token: IntegerToken, Pos: pos, N: n, S: num}
+	}
+	return Lexeme{Token: FloatToken, Pos: pos, S: num}
+}
+
+// lexRadix reads a hexadecimal (&H) or octal (&O or just &) constant. The constants are
+// 16 bits, so &H8000 to &HFFFF are negative.
+func (tr *TokenReader) lexRadix(c char) Lexeme {
+	pos := c.pos
+	prefix := "&"
+	base := 8
+	c = tr.next()
+	if c.ch == 'H' || c.ch == 'h' {
+		prefix = "&H"
+		base = 16
+		c = tr.next()
+	} else if c.ch == 'O' || c.ch == 'o' {
+		prefix = "&O"
+		c = tr.next()
+	}
+
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	var n, digits int
+	for {
+		d, err := strconv.ParseUint(string(c.ch), base, 8)
+		if err != nil {
+			break
+		}
+		if n <= 0xFFFF {
+			n = n*base + int(d)
+		}
+		sb.WriteRune(c.ch)
+		digits += 1
+		c = tr.next()
+	}
+	tr.backup(c)
+
+	if digits == 0 {
+		return Lexeme{Token: ErrorToken, Pos: pos, S: "Syntax error"}
+	} else if n > 0xFFFF {
+		return Lexeme{Token: ErrorToken, Pos: pos, S: "Overflow"}
+	} else if n > MaxInteger {
+		n -= 0x10000
+	}
+	return Lexeme{Token: IntegerToken, Pos: pos, N: n, S: strings.ToUpper(sb.String())}
+}
+
+// lexString reads a string; a string which is not closed ends at the end of the line.
+func (tr *TokenReader) lexString(c char) Lexeme {
+	pos := c.pos
+	var sb strings.Builder
+	for {
+		c = tr.next()
+		if c.ch == '"' {
+			break
+		} else if c.ch == '\n' {
+			tr.backup(c)
+			break
+		}
+		sb.WriteRune(c.ch)
+	}
+	return Lexeme{Token: StringToken, Pos: pos, S: sb.String()}
+}
+
+func (tr *TokenReader) lexRelation(c char) Lexeme {
+	pos := c.pos
+	first := c.ch
+	c = tr.next()
+	op := string(first)
+	switch {
+	case (first == '<' && c.ch == '=') || (first == '=' && c.ch == '<'):
+		op = "<="
+	case (first == '>' && c.ch == '=') || (first == '=' && c.ch == '>'):
+		op = ">="
+	case (first == '<' && c.ch == '>') || (first == '>' && c.ch == '<'):
+		op = "<>"
+	default:
+		tr.backup(c)
+	}
+	return Lexeme{Token: OperatorToken, Pos: pos, S: op}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	cases := []struct {
+		in  string
+		out []Lexeme
+	}{
+		{"10 print a%\n", []Lexeme{
+			{IntegerToken, Pos{1, 1}, 10, "10"},
+			{KeywordToken, Pos{1, 4}, 0, "PRINT"},
+			{KeywordToken, Pos{1, 10}, 0, "A%"},
+			{EndOfLine, Pos{1, 12}, 0, ""},
+		}},
+		{"a>=b a=>b a<=b a=<b a<>b a><b a>b a<b a=b", []Lexeme{
+			{KeywordToken, Pos{1, 1}, 0, "A"},
+			{OperatorToken, Pos{1, 2}, 0, ">="},
+			{KeywordToken, Pos{1, 4}, 0, "B"},
+			{KeywordToken, Pos{1, 6}, 0, "A"},
+			{OperatorToken, Pos{1, 7}, 0, ">="},
+			{KeywordToken, Pos{1, 9}, 0, "B"},
+			{KeywordToken, Pos{1, 11}, 0, "A"},
+			{OperatorToken, Pos{1, 12}, 0, "<="},
+			{KeywordToken, Pos{1, 14}, 0, "B"},
+			{KeywordToken, Pos{1, 16}, 0, "A"},
+			{OperatorToken, Pos{1, 17}, 0, "<="},
+			{KeywordToken, Pos{1, 19}, 0, "B"},
+			{KeywordToken, Pos{1, 21}, 0, "A"},
+			{OperatorToken, Pos{1, 22}, 0, "<>"},
+			{KeywordToken, Pos{1, 24}, 0, "B"},
+			{KeywordToken, Pos{1, 26}, 0, "A"},
+			{OperatorToken, Pos{1, 27}, 0, "<>"},
+			{KeywordToken, Pos{1, 29}, 0, "B"},
+			{KeywordToken, Pos{1, 31}, 0, "A"},
+			{OperatorToken, Pos{1, 32}, 0, ">"},
+			{KeywordToken, Pos{1, 33}, 0, "B"},
+			{KeywordToken, Pos{1, 35}, 0, "A"},
+			{OperatorToken, Pos{1, 36}, 0, "<"},
+			{KeywordToken, Pos{1, 37}, 0, "B"},
+			{KeywordToken, Pos{1, 39}, 0, "A"},
+			{OperatorToken, Pos{1, 40}, 0, "="},
+			{KeywordToken, Pos{1, 41}, 0, "B"},
+			{EndOfLine, Pos{1, 42}, 0, ""},
+		}},
+		{"&H7FFF &HFFFF &hff &O777 &17 &H &H10000 &", []Lexeme{
+			{IntegerToken, Pos{1, 1}, 32767, "&H7FFF"},
+			{IntegerToken, Pos{1, 8}, -1, "&HFFFF"},
+			{IntegerToken, Pos{1, 15}, 255, "&HFF"},
+			{IntegerToken, Pos{1, 20}, 511, "&O777"},
+			{IntegerToken, Pos{1, 26}, 15, "&17"},
+			{ErrorToken, Pos{1, 30}, 0, "Syntax error"},
+			{ErrorToken, Pos{1, 33}, 0, "Overflow"},
+			{ErrorToken, Pos{1, 41}, 0, "Syntax error"},
+			{EndOfLine, Pos{1, 42}, 0, ""},
+		}},
+		{"12 1.5 .5 1e10 1.5E-3 2d+5 3# 4! 5% 40000% 1.5% 1234567890 1E 2D+", []Lexeme{
+			{IntegerToken, Pos{1, 1}, 12, "12"},
+			{FloatToken, Pos{1, 4}, 0, "1.5"},
+			{FloatToken, Pos{1, 8}, 0, ".5"},
+			{FloatToken, Pos{1, 11}, 0, "1E10"},
+			{FloatToken, Pos{1, 16}, 0, "1.5E-3"},
+			{FloatToken, Pos{1, 23}, 0, "2D+5"},
+			{FloatToken, Pos{1, 28}, 0, "3#"},
+			{FloatToken, Pos{1, 31}, 0, "4!"},
+			{IntegerToken, Pos{1, 34}, 5, "5%"},
+			{ErrorToken, Pos{1, 37}, 0, "Overflow"},
+			{ErrorToken, Pos{1, 44}, 0, "Syntax error"},
+			{FloatToken, Pos{1, 49}, 0, "1234567890"},
+			{IntegerToken, Pos{1, 60}, 1, "1"},
+			{KeywordToken, Pos{1, 61}, 0, "E"},
+			{IntegerToken, Pos{1, 63}, 2, "2"},
+			{KeywordToken, Pos{1, 64}, 0, "D"},
+			{OperatorToken, Pos{1, 65}, 0, "+"},
+			{EndOfLine, Pos{1, 66}, 0, ""},
+		}},
+		{"a$ b% c! d# x1.y2 print#1 mki$(1)", []Lexeme{
+			{KeywordToken, Pos{1, 1}, 0, "A$"},
+			{KeywordToken, Pos{1, 4}, 0, "B%"},
+			{KeywordToken, Pos{1, 7}, 0, "C!"},
+			{KeywordToken, Pos{1, 10}, 0, "D#"},
+			{KeywordToken, Pos{1, 13}, 0, "X1.Y2"},
+			{KeywordToken, Pos{1, 19}, 0, "PRINT"},
+			{OperatorToken, Pos{1, 24}, 0, "#"},
+			{IntegerToken, Pos{1, 25}, 1, "1"},
+			{KeywordToken, Pos{1, 27}, 0, "MKI$"},
+			{OperatorToken, Pos{1, 31}, 0, "("},
+			{IntegerToken, Pos{1, 32}, 1, "1"},
+			{OperatorToken, Pos{1, 33}, 0, ")"},
+			{EndOfLine, Pos{1, 34}, 0, ""},
+		}},
+		{"? \"abc\" \"a\tb\"\"unterminated\n10 ' comment\n", []Lexeme{
+			{KeywordToken, Pos{1, 1}, 0, "PRINT"},
+			{StringToken, Pos{1, 3}, 0, "abc"},
+			{StringToken, Pos{1, 9}, 0, "a\tb"},
+			{StringToken, Pos{1, 14}, 0, "unterminated"},
+			{EndOfLine, Pos{1, 27}, 0, ""},
+			{IntegerToken, Pos{2, 1}, 10, "10"},
+			{EndOfLine, Pos{2, 4}, 0, "' comment"},
+		}},
+		{"\tx = 1 : y = 2 ^ 3 \\ 4 ~", []Lexeme{
+			{KeywordToken, Pos{1, 2}, 0, "X"},
+			{OperatorToken, Pos{1, 4}, 0, "="},
+			{IntegerToken, Pos{1, 6}, 1, "1"},
+			{OperatorToken, Pos{1, 8}, 0, ":"},
+			{KeywordToken, Pos{1, 10}, 0, "Y"},
+			{OperatorToken, Pos{1, 12}, 0, "="},
+			{IntegerToken, Pos{1, 14}, 2, "2"},
+			{OperatorToken, Pos{1, 16}, 0, "^"},
+			{IntegerToken, Pos{1, 18}, 3, "3"},
+			{OperatorToken, Pos{1, 20}, 0, "\\"},
+			{IntegerToken, Pos{1, 22}, 4, "4"},
+			{ErrorToken, Pos{1, 24}, 0, "Syntax error"},
+			{EndOfLine, Pos{1, 25}, 0, ""},
+		}},
+	}
+
+	for _, c := range cases {
+		tr := &TokenReader{
+			R: bufio.NewReader(strings.NewReader(c.in)),
+		}
+		var out []Lexeme
+		for tr.SkipBlankLines() {
+			for {
+				lx := tr.Next()
+				out = append(out, lx)
+				if lx.Token == EndOfLine {
+					break
+				}
+			}
+		}
+		if len(out) != len(c.out) {
+			t.Errorf("Next(%q) got %v want %v", c.in, out, c.out)
+			continue
+		}
+		for i := range out {
+			if out[i] != c.out[i] {
+				t.Errorf("Next(%q)[%d] got %v want %v", c.in, i, out[i], c.out[i])
+			}
+		}
+	}
+}
+
+func TestCrunchedTokens(t *testing.T) {
+	cases := []struct {
+		in       string
+		crunched []string
+		spaced   []string
+	}{
+		{"10 FORI=1TO10STEP2:PRINTI:NEXT",
+			[]string{"10", "FOR", "I", "=", "1", "TO", "10", "STEP", "2", ":", "PRINT", "I",
+				":", "NEXT"},
+			[]string{"10", "FORI", "=", "1", "TO10STEP2", ":", "PRINTI", ":", "NEXT"},
+		},
+		{"IFA%>=5THENPRINTA%+1ELSEGOTO100",
+			[]string{"IF", "A%", ">=", "5", "THEN", "PRINT", "A%", "+", "1", "ELSE", "GOTO",
+				"100"},
+			[]string{"IFA%", ">=", "5", "THENPRINTA%", "+", "1", "ELSEGOTO100"},
+		},
+		{"PRINT#1,MKI$(X1%):TOTAL=0",
+			[]string{"PRINT", "#", "1", ",", "MKI$", "(", "X1%", ")", ":", "TO", "TAL", "=",
+				"0"},
+			[]string{"PRINT", "#", "1", ",", "MKI$", "(", "X1%", ")", ":", "TOTAL", "=", "0"},
+		},
+		{"OPEN\"R\",1,N$:GETN%:A.BTO",
+			[]string{"OPEN", "R", ",", "1", ",", "N$", ":", "GET", "N%", ":", "A.B", "TO"},
+			[]string{"OPEN", "R", ",", "1", ",", "N$", ":", "GETN%", ":", "A.BTO"},
+		},
+	}
+
+	for _, c := range cases {
+		for _, crunched := range []bool{true, false} {
+			tr := &TokenReader{
+				R:        bufio.NewReader(strings.NewReader(c.in)),
+				Crunched: crunched,
+			}
+			var out []string
+			for {
+				lx := tr.Next()
+				if lx.Token == EndOfLine {
+					break
+				} else if lx.Token == IntegerToken {
+					out = append(out, strconv.Itoa(lx.N))
+				} else {
+					out = append(out, lx.S)
+				}
+			}
+			want := c.spaced
+			if crunched {
+				want = c.crunched
+			}
+			if !slices.Equal(out, want) {
+				t.Errorf("Next(%q, %v) got %q want %q", c.in, crunched, out, want)
+			}
+		}
+	}
+}
+
+func TestFloatValue(t *testing.T) {
+	cases := []struct {
+		s   string
+		val Value
+	}{
+		{"1.5", SingleValue(1.5)},
+		{"1.5#", DoubleValue(1.5)},
+		{"12345678", DoubleValue(12345678)},
+		{"12345678!", SingleValue(12345678)},
+		{"1E10", SingleValue(1e10)},
+		{"1D10", DoubleValue(1e10)},
+		{"1.25E-2", SingleValue(0.0125)},
+		{"0.0000001", SingleValue(0.0000001)},
+	}
+
+	for _, c := range cases {
+		val, err := floatValue(c.s)
+		if err != nil {
+			t.Errorf("floatValue(%s) failed with %s", c.s, err)
+		} else if val != c.val {
+			t.Errorf("floatValue(%s) got %v want %v", c.s, val, c.val)
+		}
+	}
+}
+
+// FuzzTokenReader checks that reading and compiling any input terminates without panicking
+// or exiting; programs are parsed but never run.
+func FuzzTokenReader(f *testing.F) {
+	seeds := []string{
+		"10 PRINT \"HELLO\"\n20 GOTO 10\n",
+		"10 FOR I=1 TO 10\n20 PRINT I\n30 NEXT\n40 FOR\n50 WHILE\n",
+		"10 IF A% >= &HFF THEN PRINT 1.5D3 ELSE PRINT #1, X$\r\n",
+		"\xEF\xBB\xBF10 ? \"unterminated\r20 REM\t\x1A",
+		"10 OPEN \"R\", #1, \"F\", 32: FIELD 1, 2 AS A$\n",
+		"&O &H1FFFF 1E+ 1.2.3 ... \"\x00\xFF\n",
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, in string) {
+		tr := &TokenReader{
+			R: bufio.NewReader(strings.NewReader(in)),
+		}
+		n := 0
+		for tr.SkipBlankLines() {
+			for tr.Next().Token != EndOfLine {
+				n += 1
+				if n > len(in)+1 {
+					t.Fatalf("too many tokens for %q", in)
+				}
+			}
+		}
+
+		b := NewBasic(io.Discard, io.Discard)
+		b.FS = NewMemFS()
+		tr = &TokenReader{
+			R: bufio.NewReader(bytes.NewBufferString(in)),
+		}
+		for tr.SkipBlankLines() {
+			if t, n, _ := tr.ReadToken(); t == IntegerToken {
+				stmt, ok := b.CompileStatement(tr, true)
+				if ok && b.CheckLine(n, stmt) {
+					b.Code.ReplaceOrInsert(Line{n, stmt})
+				}
+			} else {
+				b.Error(tr, "")
+			}
+		}
+		if b.Check() {
+			b.stepLimit = 100000
+			b.Run()
+		}
+	})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/google/btree"
+)
+
+// lineRef is a reference by a GOTO or GOSUB to a line number.
+type lineRef struct {
+	Keyword string
+	Number  int
+}
+
+// flow describes where control can go after a statement: to the targets of Gotos, to the
+// targets of GoSubs (which come back to the next line), to the next line if Next is set, to
+// the end of the program if End is set, or back after the last GOSUB if Return is set. If
+// Next is not set, Stop is the keyword, such as END or RETURN, which stops control from
+// reaching the next line.
+type flow struct {
+	Gotos  []lineRef
+	GoSubs []lineRef
+	Next   bool
+	End    bool
+	Return bool
+	Stop   string
+}
+
+func stmtFlow(stmt Stmt) flow {
+	switch stmt := stmt.(type) {
+	case GotoStmt:
+		return flow{Gotos: []lineRef{{"GOTO", stmt.Number}}, Stop: "GOTO"}
+	case GoSubStmt:
+		return flow{GoSubs: []lineRef{{"GOSUB", stmt.Number}}, Next: true}
+	case IfGotoStmt:
+		return flow{Gotos: []lineRef{{"GOTO", stmt.Number}}, Next: true}
+	case EndStmt:
+		if stmt.System {
+			return flow{End: true, Stop: "SYSTEM"}
+		}
+		return flow{End: true, Stop: "END"}
+	case ReturnStmt:
+		return flow{Return: true, Stop: "RETURN"}
+	case CommentStmt:
+		if stmt.Stmt != nil {
+			return stmtFlow(stmt.Stmt)
+		}
+	case IfThenStmt:
+		f := stmtFlow(stmt.Then)
+		els := flow{Next: true}
+		if stmt.Else != nil {
+			els = stmtFlow(stmt.Else)
+		}
+		f.Gotos = append(f.Gotos, els.Gotos...)
+		f.GoSubs = append(f.GoSubs, els.GoSubs...)
+		f.Next = f.Next || els.Next
+		f.End = f.End || els.End
+		f.Return = f.Return || els.Return
+		f.Stop = "IF"
+		return f
+	}
+	return flow{Next: true}
+}
+
+type lintProblem struct {
+	Number  int
+	Message string
+}
+
+// program is the lines of a program in order, and the flow of each line.
+type program struct {
+	lines []Line
+	flows []flow
+}
+
+func (b *Basic) program() program {
+	var p program
+	b.Code.Ascend(
+		func(item btree.Item) bool {
+			line := item.(Line)
+			p.lines = append(p.lines, line)
+			p.flows = append(p.flows, stmtFlow(line.Stmt))
+			return true
+		})
+	return p
+}
+
+type linter struct {
+	program
+	problems []lintProblem
+}
+
+func (l *linter) problem(number int, format string, args ...any) {
+	l.problems = append(l.problems, lintProblem{number, fmt.Sprintf(format, args...)})
+}
+
+// target returns the index of the line that a jump to line number n goes to: like the
+// compiled program, it is the first line numbered n or greater. If there is no such line, it
+// returns len(l.lines), which is the end of the program.
+func (p program) target(n int) int {
+	return sort.Search(len(p.lines),
+		func(i int) bool {
+			return p.lines[i].Number >= n
+		})
+}
+
+func (p program) exists(n int) bool {
+	i := p.target(n)
+	return i < len(p.lines) && p.lines[i].Number == n
+}
+
+func (l *linter) checkTargets() {
+	for i, f := range l.flows {
+		for _, ref := range append(f.Gotos, f.GoSubs...) {
+			if !l.exists(ref.Number) {
+				l.problem(l.lines[i].Number, "%s %d: line %d does not exist", ref.Keyword,
+					ref.Number, ref.Number)
+			}
+		}
+	}
+}
+
+// remark returns true if the line is only a REM or a ' comment.
+func (p program) remark(i int) bool {
+	switch stmt := p.lines[i].Stmt.(type) {
+	case RemStmt:
+		return true
+	case CommentStmt:
+		return stmt.Stmt == nil
+	}
+	return false
+}
+
+// checkReachable reports the lines which can't be reached from the start of the program.
+// Lines which are only remarks are not reported, and are skipped over when looking for the
+// GOTO, END, or RETURN before a line.
+func (l *linter) checkReachable() {
+	jumped := make([]bool, len(l.lines)+1)
+	for _, f := range l.flows {
+		for _, ref := range append(f.Gotos, f.GoSubs...) {
+			jumped[l.target(ref.Number)] = true
+		}
+	}
+	for i := range l.lines {
+		if jumped[i] && l.remark(i) {
+			jumped[i+1] = true
+		}
+	}
+
+	reached := make([]bool, len(l.lines)+1)
+	todo := []int{0}
+	for len(todo) > 0 {
+		i := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if reached[i] {
+			continue
+		}
+		reached[i] = true
+		if i == len(l.lines) {
+			continue
+		}
+
+		f := l.flows[i]
+		for _, ref := range append(f.Gotos, f.GoSubs...) {
+			todo = append(todo, l.target(ref.Number))
+		}
+		if f.Next {
+			todo = append(todo, i+1)
+		}
+	}
+
+	for i, line := range l.lines {
+		if reached[i] || l.remark(i) {
+			continue
+		}
+		prev := i - 1
+		for prev >= 0 && l.remark(prev) {
+			prev -= 1
+		}
+		if prev >= 0 && !l.flows[prev].Next && !jumped[i] {
+			l.problem(line.Number, "unreachable: follows %s on line %d and nothing jumps to it",
+				l.flows[prev].Stop, l.lines[prev].Number)
+		} else {
+			l.problem(line.Number, "unreachable")
+		}
+	}
+}
+
+// checkSubroutines reports subroutines which can get to the end of the program, or fall
+// into another subroutine, without a RETURN. A GOSUB within a subroutine is assumed to come
+// back.
+func (l *linter) checkSubroutines() {
+	subs := map[int]bool{}
+	for _, f := range l.flows {
+		for _, ref := range f.GoSubs {
+			if i := l.target(ref.Number); i < len(l.lines) {
+				subs[i] = true
+			}
+		}
+	}
+
+	var starts []int
+	for s := range subs {
+		starts = append(starts, s)
+	}
+	sort.Ints(starts)
+
+	for _, s := range starts {
+		reached := make([]bool, len(l.lines)+1)
+		todo := []int{s}
+		var end bool
+		var into []int
+		for len(todo) > 0 {
+			i := todo[len(todo)-1]
+			todo = todo[:len(todo)-1]
+			if reached[i] {
+				continue
+			}
+			reached[i] = true
+			if i == len(l.lines) {
+				end = true
+				continue
+			}
+
+			f := l.flows[i]
+			for _, ref := range f.Gotos {
+				todo = append(todo, l.target(ref.Number))
+			}
+			if f.Next {
+				if subs[i+1] {
+					into = append(into, i+1)
+				} else {
+					todo = append(todo, i+1)
+				}
+			}
+		}
+
+		number := l.lines[s].Number
+		if end {
+			l.problem(number, "subroutine can reach the end of the program without RETURN")
+		}
+		sort.Ints(into)
+		for _, i := range into {
+			l.problem(number, "subroutine can fall into the subroutine at line %d without RETURN",
+				l.lines[i].Number)
+		}
+	}
+}
+
+// checkVars reports variables which are used but never assigned, using the compiled program
+// so that every kind of statement is covered. FIELD, LSET, and RSET count as assignments.
+func (l *linter) checkVars(img *Image) {
+	assigned := map[int32]bool{}
+	type use struct {
+		number int
+		slot   int32
+	}
+	var uses []use
+	for pc := 0; pc < len(img.Code); {
+		op := Opcode(img.Code[pc])
+		switch op {
+		case OpLoad:
+			n, _ := img.LineNumber(pc)
+			uses = append(uses, use{n, img.Code[pc+1]})
+		case OpStore, OpField, OpLSet, OpRSet:
+			assigned[img.Code[pc+1]] = true
+		}
+		pc += 1 + opcodeArgs[op]
+	}
+
+	reported := map[use]bool{}
+	for _, u := range uses {
+		if !assigned[u.slot] && !reported[u] {
+			reported[u] = true
+			l.problem(u.number, "%s is used but never assigned", img.Names[u.slot])
+		}
+	}
+}
+
+// Lint checks the program for problems which the compiler does not report and writes them
+// to w as fn:line: line n: message, where line is the line of the file that line number n was
+// loaded from. It returns the number of problems, or -1 if the program can't be compiled.
+func (b *Basic) Lint(w io.Writer, fn string) int {
+	defTypes := b.DefTypes
+	img, ok := b.Compile()
+	b.DefTypes = defTypes
+	if !ok {
+		return -1
+	}
+
+	l := linter{program: b.program()}
+
+	l.checkTargets()
+	l.checkReachable()
+	l.checkSubroutines()
+	l.checkVars(img)
+
+	sort.SliceStable(l.problems,
+		func(i, j int) bool {
+			return l.problems[i].Number < l.problems[j].Number
+		})
+	for _, p := range l.problems {
+		fmt.Fprintf(w, "%s:%d: line %d: %s\n", fn, b.sourceLine(p.Number), p.Number,
+			p.Message)
+	}
+	return len(l.problems)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	cases := []struct {
+		src string
+		out string
+		n   int
+	}{
+		{`10 A = 1
+20 PRINT A
+`, "", 0},
+		{`10 GOTO 30
+20 GOSUB 40
+`, `t.bas:1: line 10: GOTO 30: line 30 does not exist
+t.bas:2: line 20: GOSUB 40: line 40 does not exist
+t.bas:2: line 20: unreachable: follows GOTO on line 10 and nothing jumps to it
+`, 3},
+		{`10 IF 1 = 2 GOTO 50
+20 END
+30 PRINT "dead"
+40 GOTO 30
+50 PRINT "live"
+`, `t.bas:3: line 30: unreachable
+t.bas:4: line 40: unreachable
+`, 2},
+		{`10 END
+20 PRINT "dead"
+30 PRINT "dead"
+`, `t.bas:2: line 20: unreachable: follows END on line 10 and nothing jumps to it
+t.bas:3: line 30: unreachable
+`, 2},
+		{`10 SYSTEM 2
+20 PRINT "dead"
+`, `t.bas:2: line 20: unreachable: follows SYSTEM on line 10 and nothing jumps to it
+`, 1},
+		{`10 GOTO 40
+20 REM dead
+30 ' also dead
+40 END
+50 ' the end
+`, "", 0},
+		{`10 END
+20 REM jumped to
+30 PRINT "dead"
+40 GOTO 20
+`, `t.bas:3: line 30: unreachable
+t.bas:4: line 40: unreachable
+`, 2},
+		{`10 A = 1
+20 IF A = 1 THEN END ELSE RETURN
+30 PRINT "dead"
+`, "t.bas:3: line 30: unreachable: follows IF on line 20 and nothing jumps to it\n", 1},
+		{`10 GOSUB 100
+20 GOSUB 200
+30 END
+100 PRINT "falls"
+200 PRINT "ok"
+210 RETURN
+`, "t.bas:4: line 100: subroutine can fall into the subroutine at line 200 without RETURN\n", 1},
+		{`10 GOSUB 100
+20 END
+100 IF A% = 0 THEN RETURN
+110 PRINT "off the end"
+`, `t.bas:3: line 100: subroutine can reach the end of the program without RETURN
+t.bas:3: line 100: A% is used but never assigned
+`, 2},
+		{`10 PRINT A$; B
+20 PRINT A$ + A$
+30 INPUT #1, B
+40 LINE INPUT #1, C$
+50 PRINT C$
+60 FIELD #1, 10 AS F$
+70 PRINT F$
+`, `t.bas:1: line 10: A$ is used but never assigned
+t.bas:2: line 20: A$ is used but never assigned
+`, 2},
+		{`
+100 PRINT "start"
+
+110 GOTO 900
+`, "t.bas:4: line 110: GOTO 900: line 900 does not exist\n", 1},
+		{`10 A = "x"
+`, "", -1},
+	}
+
+	for _, c := range cases {
+		fsys := NewMemFS()
+		writeFile(t, fsys, "t.bas", c.src)
+
+		w := &bytes.Buffer{}
+		b := NewBasic(w, w)
+		b.FS = fsys
+		if !b.Load("t.bas") {
+			if c.n != -1 {
+				t.Errorf("Load(%q) failed: %s", c.src, w.String())
+			}
+			continue
+		}
+		n := b.Lint(w, "t.bas")
+		if out := w.String(); out != c.out || n != c.n {
+			t.Errorf("Lint(%q) got %d, %q want %d, %q", c.src, n, out, c.n, c.out)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+)
+
+// Microsoft Binary Format (MBF) is the floating point format of BASIC-80. A number is
+// 0.1mmm... times 2 to the power of the exponent byte minus 128, and an exponent byte of
+// zero is the number zero. The sign is the top bit of the byte before the exponent byte,
+// where the leading 1 of the mantissa would be. Single precision numbers have 24 bits of
+// mantissa in 4 bytes and double precision numbers 56 bits in 8 bytes; both have the same
+// range, roughly 2.9E-39 to 1.7E+38, and there are no denormals, infinities, or NaNs.
+//
+// Double precision values are kept as float64s, which have 53 bits of mantissa, so decoding
+// an MBF double rounds off its extra 3 bits.
+
+const mbfBias = 128
+
+// mbfSplit returns the mantissa of f as an integer of bits bits with its top bit set, and
+// the MBF exponent byte. The mantissa is rounded with halves away from zero, as BASIC-80
+// does. It returns an exponent of zero if f is too small, and an exponent greater than 255
+// if f is too big.
+func mbfSplit(f float64, bits int) (uint64, int) {
+	if f == 0 {
+		return 0, 0
+	}
+	frac, exp := math.Frexp(math.Abs(f))
+	m := uint64(math.Round(math.Ldexp(frac, bits)))
+	if m == 1<<bits {
+		m >>= 1
+		exp += 1
+	}
+	if exp+mbfBias < 1 {
+		return 0, 0
+	}
+	return m, exp + mbfBias
+}
+
+func mbfEncode(f float64, n, bits int) (string, error) {
+	m, exp := mbfSplit(f, bits)
+	if exp > 255 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", ErrOverflow
+	}
+
+	buf := make([]byte, n)
+	if exp == 0 {
+		return string(buf), nil
+	}
+	for i := 0; i < n-1; i += 1 {
+		buf[i] = byte(m >> (8 * i))
+	}
+	buf[n-2] &= 0x7F
+	if f < 0 {
+		buf[n-2] |= 0x80
+	}
+	buf[n-1] = byte(exp)
+	return string(buf), nil
+}
+
+func mbfDecode(s string, n int) float64 {
+	if s[n-1] == 0 {
+		return 0
+	}
+	var m uint64
+	for i := n - 2; i >= 0; i -= 1 {
+		m = m<<8 | uint64(s[i])
+	}
+	m |= 1 << (8*(n-1) - 1)
+	f := math.Ldexp(float64(m), int(s[n-1])-mbfBias-8*(n-1))
+	if s[n-2]&0x80 != 0 {
+		return -f
+	}
+	return f
+}
+
+// MBFSingle encodes f as a 4 byte MBF single precision number.
+func MBFSingle(f float64) (string, error) {
+	return mbfEncode(f, 4, 24)
+}
+
+// MBFDouble encodes f as an 8 byte MBF double precision number.
+func MBFDouble(f float64) (string, error) {
+	return mbfEncode(f, 8, 56)
+}
+
+// SingleMBF decodes the 4 byte MBF single precision number at the start of s.
+func SingleMBF(s string) (float64, error) {
+	if len(s) < 4 {
+		return 0, ErrIllegalFunctionCall
+	}
+	return mbfDecode(s, 4), nil
+}
+
+// DoubleMBF decodes the 8 byte MBF double precision number at the start of s.
+func DoubleMBF(s string) (float64, error) {
+	if len(s) < 8 {
+		return 0, ErrIllegalFunctionCall
+	}
+	return mbfDecode(s, 8), nil
+}
+
+// MBFRoundSingle rounds the result of a single precision operation the way BASIC-80 does:
+// to 24 bits of mantissa with halves rounded away from zero. Results too small for MBF
+// become zero, and results too big become infinite so that they are reported as an
+// overflow.
+func MBFRoundSingle(f float64) float64 {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return f
+	}
+	m, exp := mbfSplit(f, 24)
+	if exp > 255 {
+		return math.Copysign(math.Inf(1), f)
+	}
+	if exp == 0 {
+		return 0
+	}
+	return math.Copysign(math.Ldexp(float64(m), exp-mbfBias-24), f)
+}
+
+// MBFRangeDouble limits the result of a double precision operation to the range of MBF.
+func MBFRangeDouble(f float64) float64 {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return f
+	}
+	_, exp := mbfSplit(f, 53)
+	if exp > 255 {
+		return math.Copysign(math.Inf(1), f)
+	} else if exp == 0 {
+		return 0
+	}
+	return f
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestMBF(t *testing.T) {
+	singles := []struct {
+		f float64
+		s string
+	}{
+		{0, "\x00\x00\x00\x00"},
+		{1, "\x00\x00\x00\x81"},
+		{-1, "\x00\x00\x80\x81"},
+		{0.5, "\x00\x00\x00\x80"},
+		{10, "\x00\x00\x20\x84"},
+		{-2.5, "\x00\x00\xA0\x82"},
+		{float64(float32(0.1)), "\xCD\xCC\x4C\x7D"},
+		{1e-40, "\x00\x00\x00\x00"},
+	}
+	for _, c := range singles {
+		s, err := MBFSingle(c.f)
+		if err != nil {
+			t.Errorf("MBFSingle(%g) failed with %s", c.f, err)
+		} else if s != c.s {
+			t.Errorf("MBFSingle(%g) got % X want % X", c.f, s, c.s)
+		}
+		if f, err := SingleMBF(c.s); err != nil || (f != c.f && c.f != 1e-40) {
+			t.Errorf("SingleMBF(% X) got %g, %v want %g", c.s, f, err, c.f)
+		}
+	}
+
+	s, err := MBFDouble(1)
+	if err != nil || s != "\x00\x00\x00\x00\x00\x00\x00\x81" {
+		t.Errorf("MBFDouble(1) got % X, %v", s, err)
+	}
+	for _, f := range []float64{0, 1, -1, 0.1, 1.0 / 3, 123456789.123456789, -1e38, 1e-38} {
+		s, err := MBFDouble(f)
+		if err != nil {
+			t.Errorf("MBFDouble(%g) failed with %s", f, err)
+		} else if g, err := DoubleMBF(s); err != nil || g != f {
+			t.Errorf("DoubleMBF(MBFDouble(%g)) got %g, %v", f, g, err)
+		}
+	}
+
+	for _, f := range []float64{1e39, -1.7015e38, math.Inf(1)} {
+		if _, err := MBFSingle(f); err != ErrOverflow {
+			t.Errorf("MBFSingle(%g) got error %v want %v", f, err, ErrOverflow)
+		}
+	}
+	if _, err := SingleMBF("abc"); err != ErrIllegalFunctionCall {
+		t.Errorf("SingleMBF(\"abc\") got error %v want %v", err, ErrIllegalFunctionCall)
+	}
+	if _, err := DoubleMBF("abcdefg"); err != ErrIllegalFunctionCall {
+		t.Errorf("DoubleMBF(\"abcdefg\") got error %v want %v", err, ErrIllegalFunctionCall)
+	}
+
+	rounds := []struct {
+		f, r float64
+	}{
+		{16777217, 16777218},
+		{-16777217, -16777218},
+		{16777219, 16777220},
+		{0.1, float64(float32(0.1))},
+		{1e-39, 0},
+		{1.7015e38, math.Inf(1)},
+	}
+	for _, c := range rounds {
+		if r := MBFRoundSingle(c.f); r != c.r {
+			t.Errorf("MBFRoundSingle(%g) got %g want %g", c.f, r, c.r)
+		}
+	}
+}
+
+func TestMBFArithmetic(t *testing.T) {
+	cases := []struct {
+		in, out, mbf string
+	}{
+		{"print 2097153 / 2\n", " 1048577 \n", " 1048577 \n"},
+		{"print 65536 * 65536 * 65536 * 65536 * 65536 * 65536 * 65536 * 32768\n",
+			" 1.701412E+38 \n", "basic: error: Overflow\n"},
+		{"print 12345678901 * 12345678901 * 12345678901 * 12345678901\n",
+			" 2.323057228735263D+40 \n", "basic: error: Overflow\n"},
+		{"print 8388608 + .5\n", " 8388608 \n", " 8388609 \n"},
+	}
+
+	for _, c := range cases {
+		for _, mbf := range []bool{false, true} {
+			w := &bytes.Buffer{}
+			b := NewBasic(w, w)
+			b.MBF = mbf
+			b.Program(&TokenReader{
+				R: bufio.NewReader(bytes.NewBufferString(c.in)),
+			})
+			want := c.out
+			if mbf {
+				want = c.mbf
+			}
+			if w.String() != want {
+				t.Errorf("MBF %v: %sgot %q want %q", mbf, c.in, w.String(), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+const (
+	// ZoneWidth is the width of the print zones used by ',' in PRINT.
+	ZoneWidth = 14
+
+	// DefaultWidth is the initial width of a line of output.
+	DefaultWidth = 80
+
+	// InfiniteWidth turns off wrapping of output lines.
+	InfiniteWidth = 255
+)
+
+// Printer keeps track of the column of output written to W, so that PRINT can wrap long
+// lines, move to print zones, and TAB to a column. Both the screen and files opened for
+// output have a Printer.
+type Printer struct {
+	W      io.Writer
+	Column int
+	Width  int
+}
+
+// printString outputs s, starting a new line whenever the column reaches the width of the
+// output.
+func (p *Printer) printString(s string) {
+	for len(s) > 0 {
+		if p.Width == InfiniteWidth || p.Column+len(s) <= p.Width {
+			io.WriteString(p.W, s)
+			p.Column += len(s)
+			return
+		}
+
+		n := p.Width - p.Column
+		io.WriteString(p.W, s[:n])
+		p.printNewline()
+		s = s[n:]
+	}
+}
+
+func (p *Printer) printNewline() {
+	io.WriteString(p.W, "\n")
+	p.Column = 0
+}
+
+// printValue outputs a value; a number is moved to the next line if it would not fit on the
+// current line.
+func (p *Printer) printValue(v Value) {
+	s := v.Print()
+	if v.Type.Numeric() && p.Width != InfiniteWidth && p.Column > 0 &&
+		p.Column+len(s) > p.Width {
+
+		p.printNewline()
+	}
+	p.printString(s)
+}
+
+// printComma moves to the start of the next print zone, or to the next line if there is not
+// a full zone left on the current line.
+func (p *Printer) printComma() {
+	next := (p.Column/ZoneWidth + 1) * ZoneWidth
+	if p.Width != InfiniteWidth && next+ZoneWidth > p.Width {
+		p.printNewline()
+	} else {
+		p.printString(strings.Repeat(" ", next-p.Column))
+	}
+}
+
+// printTab moves to column n, counting from one; if the output is already past that column,
+// it moves to column n of the next line.
+func (p *Printer) printTab(n int) {
+	n -= 1
+	if p.Width != InfiniteWidth {
+		n %= p.Width
+	}
+	if p.Column > n {
+		p.printNewline()
+	}
+	p.printString(strings.Repeat(" ", n-p.Column))
+}
+
+func (p *Printer) printSpaces(n int) {
+	if p.Width != InfiniteWidth {
+		n %= p.Width
+	}
+	p.printString(strings.Repeat(" ", n))
+}
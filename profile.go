@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LineProfile is how many times a line ran, and the wall time spent running it.
+type LineProfile struct {
+	Count int64
+	Time  time.Duration
+}
+
+// Branch is how many times the THEN and the ELSE of an IF were taken; the ELSE of an IF
+// without one is going on to the next line.
+type Branch struct {
+	Then, Else int64
+}
+
+// Profile records, by line number, how many times each line of a program runs and how long
+// is spent in it, and how many times each way of the IFs on the line was taken. A Profile
+// collects the runs of all programs while it is b.Profile.
+type Profile struct {
+	Lines    map[int]*LineProfile
+	Branches map[int][]Branch
+	Start    time.Time
+
+	now func() time.Time
+}
+
+func NewProfile() *Profile {
+	return &Profile{
+		Lines:    map[int]*LineProfile{},
+		Branches: map[int][]Branch{},
+		Start:    time.Now(),
+		now:      time.Now,
+	}
+}
+
+// imageBranches returns, for each line of img, the pcs of the OpJumpFalse of its IFs.
+func imageBranches(img *Image) [][]int {
+	branches := make([][]int, len(img.Lines))
+	k := -1
+	for pc := 0; pc < len(img.Code); pc += 1 + opcodeArgs[Opcode(img.Code[pc])] {
+		for k+1 < len(img.Lines) && img.Lines[k+1].PC <= pc {
+			k += 1
+		}
+		if k >= 0 && Opcode(img.Code[pc]) == OpJumpFalse {
+			branches[k] = append(branches[k], pc)
+		}
+	}
+	return branches
+}
+
+// profiler profiles one execution of an image: lineOf is the index in img.Lines of the line
+// containing each instruction, or -1, and first is set for the first instruction of each line.
+// jump is the pc of the OpJumpFalse just executed, or -1, and taken counts the ways taken at
+// each OpJumpFalse.
+type profiler struct {
+	prof   *Profile
+	img    *Image
+	lineOf []int32
+	first  []bool
+	counts []int64
+	times  []time.Duration
+	cur    int32
+	t      time.Time
+	jump   int
+	taken  map[int]*Branch
+}
+
+func newProfiler(prof *Profile, img *Image) *profiler {
+	p := &profiler{
+		prof:   prof,
+		img:    img,
+		lineOf: make([]int32, len(img.Code)),
+		first:  make([]bool, len(img.Code)),
+		counts: make([]int64, len(img.Lines)),
+		times:  make([]time.Duration, len(img.Lines)),
+		cur:    -1,
+		jump:   -1,
+		taken:  map[int]*Branch{},
+	}
+	for pc := range p.lineOf {
+		p.lineOf[pc] = -1
+	}
+	for k, line := range img.Lines {
+		end := len(img.Code)
+		if k+1 < len(img.Lines) {
+			end = img.Lines[k+1].PC
+		}
+		for pc := line.PC; pc < end; pc += 1 {
+			p.lineOf[pc] = int32(k)
+		}
+		if line.PC < end {
+			p.first[line.PC] = true
+		}
+	}
+	return p
+}
+
+// step is called before the instruction at pc is executed.
+func (p *profiler) step(pc int) {
+	if p.jump >= 0 {
+		br, ok := p.taken[p.jump]
+		if !ok {
+			br = &Branch{}
+			p.taken[p.jump] = br
+		}
+		if pc == p.jump+2 {
+			br.Then += 1
+		} else {
+			br.Else += 1
+		}
+		p.jump = -1
+	}
+	if Opcode(p.img.Code[pc]) == OpJumpFalse {
+		p.jump = pc
+	}
+
+	k := p.lineOf[pc]
+	if p.first[pc] {
+		p.counts[k] += 1
+	}
+	if k != p.cur {
+		now := p.prof.now()
+		if p.cur >= 0 {
+			p.times[p.cur] += now.Sub(p.t)
+		}
+		p.cur = k
+		p.t = now
+	}
+}
+
+// stop adds the counts and times to the profile.
+func (p *profiler) stop() {
+	if p.cur >= 0 {
+		p.times[p.cur] += p.prof.now().Sub(p.t)
+	}
+	for k, line := range p.img.Lines {
+		if p.counts[k] == 0 && p.times[k] == 0 {
+			continue
+		}
+		lp, ok := p.prof.Lines[line.Number]
+		if !ok {
+			lp = &LineProfile{}
+			p.prof.Lines[line.Number] = lp
+		}
+		lp.Count += p.counts[k]
+		lp.Time += p.times[k]
+	}
+
+	for k, pcs := range imageBranches(p.img) {
+		if len(pcs) == 0 || p.counts[k] == 0 {
+			continue
+		}
+		n := p.img.Lines[k].Number
+		branches := p.prof.Branches[n]
+		for len(branches) < len(pcs) {
+			branches = append(branches, Branch{})
+		}
+		for i, pc := range pcs {
+			if br, ok := p.taken[pc]; ok {
+				branches[i].Then += br.Then
+				branches[i].Else += br.Else
+			}
+		}
+		p.prof.Branches[n] = branches
+	}
+}
+
+// hot returns the line numbers in the profile, the lines with the most time first.
+func (prof *Profile) hot() []int {
+	var numbers []int
+	for n := range prof.Lines {
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers,
+		func(i, j int) bool {
+			li, lj := prof.Lines[numbers[i]], prof.Lines[numbers[j]]
+			if li.Time != lj.Time {
+				return li.Time > lj.Time
+			} else if li.Count != lj.Count {
+				return li.Count > lj.Count
+			}
+			return numbers[i] < numbers[j]
+		})
+	return numbers
+}
+
+// lineText returns line n of the program, or an empty string if there isn't one.
+func (b *Basic) lineText(n int) string {
+	item := b.Code.Get(Line{n, nil})
+	if item == nil {
+		return ""
+	}
+	var sb strings.Builder
+	item.(Line).Stmt.Print(&sb)
+	return sb.String()
+}
+
+// WriteProfile writes a report of the profile to w, with the lines which took the most time
+// first.
+func (b *Basic) WriteProfile(w io.Writer, prof *Profile) {
+	var total time.Duration
+	for _, lp := range prof.Lines {
+		total += lp.Time
+	}
+
+	fmt.Fprintf(w, "%6s %10s %12s %7s  %s\n", "LINE", "COUNT", "SECONDS", "%", "STATEMENT")
+	for _, n := range prof.hot() {
+		lp := prof.Lines[n]
+		var pct float64
+		if total > 0 {
+			pct = float64(lp.Time) * 100 / float64(total)
+		}
+		fmt.Fprintf(w, "%6d %10d %12.6f %7.2f  %s\n", n, lp.Count, lp.Time.Seconds(), pct,
+			b.lineText(n))
+	}
+}
+
+// protoWriter encodes the protocol buffer messages of a pprof profile.
+type protoWriter struct {
+	buf []byte
+}
+
+func (pw *protoWriter) varint(field int, v uint64) {
+	pw.buf = binary.AppendUvarint(pw.buf, uint64(field)<<3)
+	pw.buf = binary.AppendUvarint(pw.buf, v)
+}
+
+func (pw *protoWriter) bytes(field int, b []byte) {
+	pw.buf = binary.AppendUvarint(pw.buf, uint64(field)<<3|2)
+	pw.buf = binary.AppendUvarint(pw.buf, uint64(len(b)))
+	pw.buf = append(pw.buf, b...)
+}
+
+func (pw *protoWriter) message(field int, fn func(msg *protoWriter)) {
+	var msg protoWriter
+	fn(&msg)
+	pw.bytes(field, msg.buf)
+}
+
+// WritePprof writes the profile to w as a gzipped profile.proto, which go tool pprof can
+// read. Each line is a function, named by its line number and statement, with samples of how
+// many times it ran and the time spent in it. The file name of the functions is fn.
+func (b *Basic) WritePprof(w io.Writer, prof *Profile, fn string) error {
+	strs := []string{""}
+	index := map[string]int{"": 0}
+	str := func(s string) uint64 {
+		n, ok := index[s]
+		if !ok {
+			n = len(strs)
+			strs = append(strs, s)
+			index[s] = n
+		}
+		return uint64(n)
+	}
+
+	// Profile messages: sample_type = 1, sample = 2, location = 4, function = 5,
+	// string_table = 6, time_nanos = 9, duration_nanos = 10, default_sample_type = 14.
+	var pw protoWriter
+	for _, st := range [][2]string{{"calls", "count"}, {"time", "nanoseconds"}} {
+		pw.message(1,
+			func(vt *protoWriter) {
+				vt.varint(1, str(st[0]))
+				vt.varint(2, str(st[1]))
+			})
+	}
+
+	var total time.Duration
+	for i, n := range prof.hot() {
+		lp := prof.Lines[n]
+		total += lp.Time
+		id := uint64(i + 1)
+		pw.message(2,
+			func(s *protoWriter) {
+				s.varint(1, id)
+				s.varint(2, uint64(lp.Count))
+				s.varint(2, uint64(lp.Time.Nanoseconds()))
+			})
+		pw.message(4,
+			func(loc *protoWriter) {
+				loc.varint(1, id)
+				loc.message(4,
+					func(line *protoWriter) {
+						line.varint(1, id)
+						line.varint(2, uint64(n))
+					})
+			})
+		name := str(strings.TrimSpace(fmt.Sprintf("%d %s", n, b.lineText(n))))
+		pw.message(5,
+			func(f *protoWriter) {
+				f.varint(1, id)
+				f.varint(2, name)
+				f.varint(3, name)
+				f.varint(4, str(fn))
+				f.varint(5, uint64(n))
+			})
+	}
+	timeType := str("time")
+
+	for _, s := range strs {
+		pw.bytes(6, []byte(s))
+	}
+	pw.varint(9, uint64(prof.Start.UnixNano()))
+	pw.varint(10, uint64(total.Nanoseconds()))
+	pw.varint(14, timeType)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(pw.buf); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// SaveProfile writes b.Profile to the file fn, as a report or, if pprof is set, for go tool
+// pprof with program as the file name of the lines.
+func (b *Basic) SaveProfile(fn string, pprof bool, program string) bool {
+	f, err := Create(b.FS, fn)
+	if err != nil {
+		fmt.Fprintf(b.ErrW, "basic: error: PROFILE: %s\n", err)
+		return false
+	}
+	defer f.Close()
+
+	if pprof {
+		err = b.WritePprof(f, b.Profile, program)
+	} else {
+		w := bufio.NewWriter(f)
+		b.WriteProfile(w, b.Profile)
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(b.ErrW, "basic: error: PROFILE: %s\n", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfile(t *testing.T) {
+	src := `10 I% = 0
+20 I% = I% + 1
+30 IF I% < 3 GOTO 20
+40 GOSUB 100
+50 END
+100 RETURN
+`
+	w := &bytes.Buffer{}
+	b := NewBasic(w, w)
+	b.FS = NewMemFS()
+	writeFile(t, b.FS, "t.bas", src)
+	if !b.Load("t.bas") {
+		t.Fatalf("Load failed: %s", w.String())
+	}
+
+	// The clock moves one millisecond each time it is read, which is when control moves
+	// from one line to another.
+	b.Profile = NewProfile()
+	var now time.Time
+	b.Profile.now = func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+	b.Run()
+
+	want := map[int]LineProfile{
+		10:  {1, time.Millisecond},
+		20:  {3, 3 * time.Millisecond},
+		30:  {3, 3 * time.Millisecond},
+		40:  {1, time.Millisecond},
+		50:  {1, time.Millisecond},
+		100: {1, time.Millisecond},
+	}
+	if len(b.Profile.Lines) != len(want) {
+		t.Errorf("Profile got %d lines want %d", len(b.Profile.Lines), len(want))
+	}
+	for n, lp := range want {
+		if got, ok := b.Profile.Lines[n]; !ok || *got != lp {
+			t.Errorf("Profile(%d) got %v want %v", n, got, lp)
+		}
+	}
+
+	b.WriteProfile(w, b.Profile)
+	report := `  LINE      COUNT      SECONDS       %  STATEMENT
+    20          3     0.003000   30.00  I% = I% + 1
+    30          3     0.003000   30.00  IF I% < 3 GOTO 20
+    10          1     0.001000   10.00  I% = 0
+    40          1     0.001000   10.00  GOSUB 100
+    50          1     0.001000   10.00  END
+   100          1     0.001000   10.00  RETURN
+`
+	if out := w.String(); out != report {
+		t.Errorf("WriteProfile got:\n%swant:\n%s", out, report)
+	}
+
+	w.Reset()
+	if err := b.WritePprof(w, b.Profile, "t.bas"); err != nil {
+		t.Fatalf("WritePprof failed with %s", err)
+	}
+	zr, err := gzip.NewReader(w)
+	if err != nil {
+		t.Fatalf("gzip.NewReader failed with %s", err)
+	}
+	buf, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("ReadAll failed with %s", err)
+	}
+	for _, s := range []string{"calls", "nanoseconds", "t.bas", "20 I% = I% + 1", "100 RETURN"} {
+		if !strings.Contains(string(buf), s) {
+			t.Errorf("WritePprof: missing %q", s)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+10 PRINT "before"
+20 A% = 32767
+30 A% = A% + 1
+40 PRINT "not reached"
@@ -0,0 +1,10 @@
+10 OPEN "O", #1, "conform.dat"
+20 WRITE #1, "one", 1, 2.5
+30 PRINT #1, "two"
+40 CLOSE #1
+50 OPEN "I", #1, "conform.dat"
+60 INPUT #1, A$, B%, C!
+70 LINE INPUT #1, D$
+80 PRINT A$, B%, C!, D$
+100 CLOSE
+110 KILL "conform.dat"
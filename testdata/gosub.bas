@@ -0,0 +1,8 @@
+10 I% = 0
+20 I% = I% + 1
+30 GOSUB 100
+40 IF I% < 5 GOTO 20
+50 PRINT "done"
+60 END
+100 IF I% = (I% \ 2) * 2 THEN PRINT I%; "is even" ELSE PRINT I%; "is odd"
+110 RETURN
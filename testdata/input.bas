@@ -0,0 +1,8 @@
+10 INPUT "What is your name"; N$
+20 INPUT "Two numbers", A, B
+30 PRINT "Hello, "; N$; "! The sum is"; A + B
+40 LINE INPUT "Say something: "; S$
+50 PRINT "You said: "; S$
+60 INPUT X%
+70 PRINT X% * 2
+80 INPUT Y
@@ -0,0 +1,8 @@
+10 REM PRINT formats numbers with a leading sign space and a trailing space
+20 PRINT 123, -45, 1.5
+30 PRINT "A"; "B", "C"
+40 PRINT 1234 / 56, 7 \ 2
+50 PRINT 40000 * 40000
+60 PRINT USING "##.##"; 3.14159
+70 A$ = "say " + CHR$(34) + "hi" + CHR$(34)
+80 PRINT A$
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/btree"
+)
+
+// unitTest is a test in a test program: a line which is REM TEST or ' TEST followed by the
+// name of the test. The test runs from the line, as a subroutine, until it RETURNs or ENDs,
+// and fails if it stops with an error, such as a failed ASSERT.
+type unitTest struct {
+	Number int
+	Name   string
+}
+
+// testName returns the name of the test if stmt starts a test.
+func testName(stmt Stmt) (string, bool) {
+	var text string
+	switch stmt := stmt.(type) {
+	case RemStmt:
+		text = string(stmt)
+	case CommentStmt:
+		if stmt.Stmt != nil {
+			return "", false
+		}
+		text = strings.TrimPrefix(stmt.Comment, "'")
+	default:
+		return "", false
+	}
+
+	text = strings.TrimSpace(text)
+	if len(text) < 4 || !strings.EqualFold(text[:4], "TEST") ||
+		(len(text) > 4 && text[4] != ' ' && text[4] != '\t') {
+		return "", false
+	}
+	return strings.TrimSpace(text[4:]), true
+}
+
+// unitTests returns the tests in the program, in order of line number.
+func (b *Basic) unitTests() []unitTest {
+	var tests []unitTest
+	b.Code.Ascend(
+		func(item btree.Item) bool {
+			line := item.(Line)
+			if name, ok := testName(line.Stmt); ok {
+				tests = append(tests, unitTest{line.Number, name})
+			}
+			return true
+		})
+	return tests
+}
+
+// TestFiles returns the test programs, which end in _test.bas, in the directories in args,
+// and the files in args. If args is empty, the test programs in the current directory are
+// returned.
+func TestFiles(fsys FS, args []string) ([]string, error) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	var files []string
+	for _, arg := range args {
+		fi, err := fs.Stat(fsys, arg)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+
+		entries, err := fs.ReadDir(fsys, arg)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, de := range entries {
+			if !de.IsDir() && strings.HasSuffix(strings.ToLower(de.Name()), "_test.bas") {
+				names = append(names, path.Join(arg, de.Name()))
+			}
+		}
+		sort.Strings(names)
+		files = append(files, names...)
+	}
+	return files, nil
+}
+
+// programUnderTest returns the program tested by the test program fn: x_test.bas tests
+// x.bas.
+func programUnderTest(fn string) string {
+	n := len(fn) - len("_test.bas")
+	if n <= 0 || !strings.EqualFold(fn[n:], "_test.bas") {
+		return ""
+	}
+	return fn[:n] + fn[n+len("_test"):]
+}
+
+// RunTests runs the tests in each of the test programs in files, and reports the failed tests
+// and the results of each test program to w. If there is a program under test, it is loaded,
+// and the test program is merged into it. It returns the numbers of tests which passed and
+// failed; a test program which can't be loaded counts as a failed test.
+func (b *Basic) RunTests(w io.Writer, files []string) (int, int) {
+	var passed, failed int
+	errW := b.ErrW
+	defer func() {
+		b.ErrW = errW
+	}()
+
+	for _, fn := range files {
+		var out bytes.Buffer
+		b.ErrW = &out
+
+		ok := true
+		if prog := programUnderTest(fn); prog != "" {
+			if _, err := fs.Stat(b.FS, prog); err == nil {
+				ok = b.Load(prog) && b.Merge(fn)
+			} else {
+				ok = b.Load(fn)
+			}
+		} else {
+			ok = b.Load(fn)
+		}
+		var img *Image
+		if ok {
+			img, ok = b.Compile()
+		}
+		if !ok {
+			failed += 1
+			fmt.Fprintf(w, "--- FAIL: %s\n", fn)
+			writeTestErrors(w, &out)
+			fmt.Fprintf(w, "FAIL\t%s\t[load failed]\n", fn)
+			continue
+		}
+
+		tests := b.unitTests()
+		if len(tests) == 0 {
+			fmt.Fprintf(w, "?\t%s\t[no tests]\n", fn)
+			continue
+		}
+
+		var filePassed, fileFailed int
+		for _, test := range tests {
+			b.CloseFiles()
+			for i := range b.Vars {
+				b.Vars[i] = Value{}
+			}
+			out.Reset()
+
+			// The test is run as a subroutine which returns to the OpEnd at the end of img.
+			pc := img.Lines[sort.Search(len(img.Lines),
+				func(i int) bool {
+					return img.Lines[i].Number >= test.Number
+				})].PC
+			if b.execute(img, pc, []Ctx{{GoSubCtx, len(img.Code) - 1}}) {
+				filePassed += 1
+				continue
+			}
+
+			fileFailed += 1
+			fmt.Fprintf(w, "--- FAIL: %s\n", strings.TrimSpace(fmt.Sprintf("%d %s", test.Number,
+				test.Name)))
+			writeTestErrors(w, &out)
+		}
+		b.CloseFiles()
+
+		if fileFailed > 0 {
+			fmt.Fprintf(w, "FAIL\t%s\t%d passed, %d failed\n", fn, filePassed, fileFailed)
+		} else {
+			fmt.Fprintf(w, "ok\t%s\t%d passed\n", fn, filePassed)
+		}
+		passed += filePassed
+		failed += fileFailed
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(w, "FAIL: %d passed, %d failed\n", passed, failed)
+	} else {
+		fmt.Fprintf(w, "PASS: %d passed\n", passed)
+	}
+	return passed, failed
+}
+
+// writeTestErrors writes the errors in out, without the basic: error: before each of them,
+// indented below a failed test.
+func writeTestErrors(w io.Writer, out *bytes.Buffer) {
+	for _, line := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+		if line != "" {
+			fmt.Fprintf(w, "    %s\n", strings.TrimPrefix(line, "basic: error: "))
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRunTests(t *testing.T) {
+	fsys := NewMemFS()
+	writeFile(t, fsys, "add.bas", `10 A = 2
+20 B = 3
+30 GOSUB 100
+40 PRINT C
+50 END
+100 C = A + B
+110 RETURN
+`)
+	writeFile(t, fsys, "add_test.bas", `1000 REM TEST adds
+1010 A = 2
+1020 B = 2
+1030 GOSUB 100
+1040 ASSERT C = 4, "2 + 2 is 4"
+1050 RETURN
+2000 ' TEST fails
+2010 A = 1
+2020 B = 1
+2030 GOSUB 100
+2040 ASSERT C = 3, "1 + 1 is not 3"
+2050 RETURN
+3000 REM TEST ends
+3010 C = 0
+3020 ASSERT C = 0
+3030 END
+4000 REM TESTS are not tests
+5000 REM TEST
+5010 PRINT "no name"
+5020 RETURN
+`)
+	writeFile(t, fsys, "bad_test.bas", "10 REM TEST bad\n20 ASSERT \"a\"\n")
+	writeFile(t, fsys, "none_test.bas", "10 REM no tests\n")
+	writeFile(t, fsys, "dir/one_test.bas", "10 REM TEST one\n20 ASSERT 1 < 2\n30 RETURN\n")
+	writeFile(t, fsys, "dir/one.txt", "")
+
+	files, err := TestFiles(fsys, nil)
+	if err != nil {
+		t.Fatalf("TestFiles failed with %s", err)
+	}
+	want := []string{"add_test.bas", "bad_test.bas", "none_test.bas"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("TestFiles got %v want %v", files, want)
+	}
+	files, err = TestFiles(fsys, []string{"dir", "add_test.bas"})
+	if err != nil {
+		t.Fatalf("TestFiles failed with %s", err)
+	}
+	want = []string{"dir/one_test.bas", "add_test.bas"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("TestFiles got %v want %v", files, want)
+	}
+
+	cases := []struct {
+		files          []string
+		passed, failed int
+		out            string
+	}{
+		{[]string{"dir/one_test.bas"}, 1, 0, `ok	dir/one_test.bas	1 passed
+PASS: 1 passed
+`},
+		{[]string{"add_test.bas", "bad_test.bas", "none_test.bas", "dir/one_test.bas"}, 4, 2,
+			`--- FAIL: 2000 fails
+    2040: ASSERT failed: 1 + 1 is not 3
+no name
+FAIL	add_test.bas	3 passed, 1 failed
+--- FAIL: bad_test.bas
+    20: Type mismatch
+FAIL	bad_test.bas	[load failed]
+?	none_test.bas	[no tests]
+ok	dir/one_test.bas	1 passed
+FAIL: 4 passed, 2 failed
+`},
+	}
+
+	for _, c := range cases {
+		var w, errW bytes.Buffer
+		b := NewBasic(&w, &errW)
+		b.FS = fsys
+		passed, failed := b.RunTests(&w, c.files)
+		if passed != c.passed || failed != c.failed {
+			t.Errorf("RunTests(%v) got %d passed %d failed want %d passed %d failed", c.files,
+				passed, failed, c.passed, c.failed)
+		}
+		if out := w.String(); out != c.out {
+			t.Errorf("RunTests(%v) got:\n%swant:\n%s", c.files, out, c.out)
+		}
+		if errW.Len() > 0 {
+			t.Errorf("RunTests(%v) wrote errors: %s", c.files, errW.String())
+		}
+	}
+}
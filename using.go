@@ -0,0 +1,355 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MaxUsingDigits is the maximum number of digits in a numeric field of a PRINT USING format.
+const MaxUsingDigits = 24
+
+// usingField is a field of a PRINT USING format. String fields are '!' (the first character),
+// '\' (width characters), and '&' (the whole string); numeric fields are '#'.
+type usingField struct {
+	kind  byte
+	width int
+
+	plus       bool // leading +
+	trailPlus  bool // trailing +
+	trailMinus bool // trailing -
+	star       bool // ** fills leading spaces with asterisks
+	dollar     bool // $$ or **$ puts a dollar sign before the number
+	comma      bool // , before the decimal point separates thousands
+	point      bool
+	exp        bool // ^^^^ uses scientific notation
+	left       int  // positions to the left of the decimal point
+	right      int  // digits to the right of the decimal point
+}
+
+func numericStart(format string, i int) bool {
+	return strings.HasPrefix(format[i:], "#") || strings.HasPrefix(format[i:], ".#") ||
+		strings.HasPrefix(format[i:], "**") || strings.HasPrefix(format[i:], "$$")
+}
+
+// parseField returns the field at the start of format[i:] and its length, or a length of
+// zero if there is no field there.
+func parseField(format string, i int) (usingField, int) {
+	var f usingField
+
+	switch format[i] {
+	case '!', '&':
+		f.kind = format[i]
+		return f, 1
+	case '\\':
+		j := i + 1
+		for j < len(format) && format[j] == ' ' {
+			j += 1
+		}
+		if j == len(format) || format[j] != '\\' {
+			return f, 0
+		}
+		f.kind = '\\'
+		f.width = j - i + 1
+		return f, f.width
+	}
+
+	j := i
+	if format[j] == '+' {
+		if j+1 == len(format) || !numericStart(format, j+1) {
+			return f, 0
+		}
+		f.plus = true
+		j += 1
+	} else if !numericStart(format, j) {
+		return f, 0
+	}
+	f.kind = '#'
+
+	if strings.HasPrefix(format[j:], "**") {
+		f.star = true
+		f.left += 2
+		j += 2
+		if j < len(format) && format[j] == '$' {
+			f.dollar = true
+			f.left += 1
+			j += 1
+		}
+	} else if strings.HasPrefix(format[j:], "$$") {
+		f.dollar = true
+		f.left += 2
+		j += 2
+	}
+
+	for j < len(format) && (format[j] == '#' || format[j] == ',') {
+		if format[j] == ',' {
+			f.comma = true
+		}
+		f.left += 1
+		j += 1
+	}
+	if j < len(format) && format[j] == '.' {
+		f.point = true
+		j += 1
+		for j < len(format) && format[j] == '#' {
+			f.right += 1
+			j += 1
+		}
+	}
+	if strings.HasPrefix(format[j:], "^^^^") {
+		f.exp = true
+		j += 4
+	}
+	if !f.plus && j < len(format) {
+		if format[j] == '+' {
+			f.trailPlus = true
+			j += 1
+		} else if format[j] == '-' {
+			f.trailMinus = true
+			j += 1
+		}
+	}
+	return f, j - i
+}
+
+// FormatUsing formats values the way PRINT USING does. Each value uses the next field of
+// format, starting over at the beginning of format when there are no more fields; the
+// literal text in format up to the field following the last value is included.
+func FormatUsing(format string, vals []Value) (string, error) {
+	var sb strings.Builder
+
+	i := 0
+	found := false
+	for n := 0; ; {
+		if i == len(format) {
+			if n == len(vals) {
+				break
+			}
+			if !found {
+				return "", ErrIllegalFunctionCall
+			}
+			i = 0
+		}
+
+		if format[i] == '_' && i+1 < len(format) {
+			sb.WriteByte(format[i+1])
+			i += 2
+			continue
+		}
+
+		f, l := parseField(format, i)
+		if l == 0 {
+			sb.WriteByte(format[i])
+			i += 1
+			continue
+		}
+		found = true
+		if n == len(vals) {
+			break
+		}
+
+		s, err := f.format(vals[n])
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(s)
+		i += l
+		n += 1
+	}
+
+	return sb.String(), nil
+}
+
+func (f usingField) format(v Value) (string, error) {
+	if f.kind == '#' {
+		if !v.Type.Numeric() {
+			return "", ErrTypeMismatch
+		}
+		if f.left+f.right > MaxUsingDigits {
+			return "", ErrIllegalFunctionCall
+		}
+		return f.formatNumber(v), nil
+	}
+
+	if v.Type != StringType {
+		return "", ErrTypeMismatch
+	}
+	switch f.kind {
+	case '!':
+		if v.String == "" {
+			return " ", nil
+		}
+		return v.String[:1], nil
+	case '\\':
+		if len(v.String) >= f.width {
+			return v.String[:f.width], nil
+		}
+		return v.String + strings.Repeat(" ", f.width-len(v.String)), nil
+	default:
+		return v.String, nil
+	}
+}
+
+// decimalDigits returns the significant digits and exponent of a number, rounded to the
+// number of digits that PRINT shows for its type; the value is 0.ddd times 10 to the
+// exponent.
+func decimalDigits(v Value) (string, int, bool) {
+	switch v.Type {
+	case IntegerType:
+		d, e := printDigits(float64(v.Integer), 16)
+		return d, e, v.Integer < 0
+	case SingleType:
+		d, e := printDigits(v.Float, 7)
+		return d, e, v.Float < 0
+	default:
+		d, e := printDigits(v.Float, 16)
+		return d, e, v.Float < 0
+	}
+}
+
+// roundDigits rounds the digits of a decimal to n digits, rounding halves away from zero.
+func roundDigits(d string, exp, n int) (string, int) {
+	if n < 0 {
+		return "", exp
+	}
+	if len(d) <= n {
+		return d, exp
+	}
+
+	round := d[n] >= '5'
+	buf := []byte(d[:n])
+	if round {
+		i := n - 1
+		for ; i >= 0; i -= 1 {
+			if buf[i] < '9' {
+				buf[i] += 1
+				break
+			}
+			buf[i] = '0'
+		}
+		if i < 0 {
+			buf = append([]byte{'1'}, buf...)
+			exp += 1
+		}
+	}
+	return strings.TrimRight(string(buf), "0"), exp
+}
+
+func digitsAt(d string, from, to int) string {
+	var sb strings.Builder
+	for i := from; i < to; i += 1 {
+		if i >= 0 && i < len(d) {
+			sb.WriteByte(d[i])
+		} else {
+			sb.WriteByte('0')
+		}
+	}
+	return sb.String()
+}
+
+func addCommas(s string) string {
+	var sb strings.Builder
+	for i := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func (f usingField) formatNumber(v Value) string {
+	d, exp, neg := decimalDigits(v)
+
+	var ip, fp, es string
+	if f.exp {
+		ld := f.left
+		if !f.plus && !f.trailPlus && !f.trailMinus && ld > 0 {
+			ld -= 1
+		}
+		n := ld + f.right
+		if n == 0 {
+			n = 1
+		}
+		d, exp = roundDigits(d, exp, n)
+		e := exp - ld
+		if d == "" {
+			e = 0
+		}
+		ip = strings.TrimLeft(digitsAt(d, 0, ld), "0")
+		fp = digitsAt(d, ld, ld+f.right)
+
+		es = "E+"
+		if e < 0 {
+			es = "E-"
+			e = -e
+		}
+		if e < 10 {
+			es += "0"
+		}
+		es += strconv.Itoa(e)
+	} else {
+		d, exp = roundDigits(d, exp, exp+f.right)
+		ip = strings.TrimLeft(digitsAt(d, 0, exp), "0")
+		fp = digitsAt(d, exp, exp+f.right)
+		if f.comma {
+			ip = addCommas(ip)
+		}
+	}
+	if d == "" {
+		neg = false
+	}
+
+	var sign string
+	if f.plus {
+		if neg {
+			sign = "-"
+		} else {
+			sign = "+"
+		}
+	} else if neg && !f.trailPlus && !f.trailMinus {
+		sign = "-"
+	}
+	var dollar string
+	if f.dollar {
+		dollar = "$"
+	}
+
+	avail := f.left
+	if f.plus {
+		avail += 1
+	}
+	body := sign + dollar + ip
+	if ip == "" && len(body) < avail {
+		body += "0"
+	}
+
+	var sb strings.Builder
+	if len(body) > avail {
+		sb.WriteByte('%')
+	} else if f.star {
+		sb.WriteString(strings.Repeat("*", avail-len(body)))
+	} else {
+		sb.WriteString(strings.Repeat(" ", avail-len(body)))
+	}
+	sb.WriteString(body)
+	if f.point {
+		sb.WriteByte('.')
+		sb.WriteString(fp)
+	}
+	sb.WriteString(es)
+
+	if f.trailPlus {
+		if neg {
+			sb.WriteByte('-')
+		} else {
+			sb.WriteByte('+')
+		}
+	} else if f.trailMinus {
+		if neg {
+			sb.WriteByte('-')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	return sb.String()
+}
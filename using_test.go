@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFormatUsing(t *testing.T) {
+	cases := []struct {
+		format string
+		vals   []Value
+		s      string
+		err    error
+	}{
+		{"!", []Value{StringValue("ABC")}, "A", nil},
+		{"!", []Value{StringValue("")}, " ", nil},
+		{"\\  \\", []Value{StringValue("LOOK"), StringValue("OUT")}, "LOOKOUT ", nil},
+		{"\\  \\", []Value{StringValue("LOOKING")}, "LOOK", nil},
+		{"& and &", []Value{StringValue("this"), StringValue("that")}, "this and that", nil},
+		{"##.##", []Value{SingleValue(.78)}, " 0.78", nil},
+		{"###.##", []Value{SingleValue(987.654)}, "987.65", nil},
+		{"##.## ", []Value{SingleValue(10.2), SingleValue(5.3), SingleValue(66.789),
+			SingleValue(.234)}, "10.20  5.30 66.79  0.23 ", nil},
+		{"+##.## ", []Value{SingleValue(-68.95), SingleValue(2.4), SingleValue(55.6),
+			SingleValue(-.9)}, "-68.95  +2.40 +55.60  -0.90 ", nil},
+		{"##.##- ", []Value{SingleValue(-68.95), SingleValue(22.449), SingleValue(-7.01)},
+			"68.95- 22.45   7.01- ", nil},
+		{"**#.# ", []Value{SingleValue(12.39), SingleValue(-.9), SingleValue(765.1)},
+			"*12.4 *-0.9 765.1 ", nil},
+		{"$$###.##", []Value{SingleValue(456.78)}, " $456.78", nil},
+		{"**$##.##", []Value{SingleValue(2.34)}, "***$2.34", nil},
+		{"####,.##", []Value{SingleValue(1234.5)}, "1,234.50", nil},
+		{"####.##,", []Value{SingleValue(1234.5)}, "1234.50,", nil},
+		{"##.##^^^^", []Value{SingleValue(234.56)}, " 2.35E+02", nil},
+		{".####^^^^-", []Value{SingleValue(-888888)}, ".8889E+06-", nil},
+		{"+.##^^^^", []Value{IntegerValue(123)}, "+.12E+03", nil},
+		{"##.##", []Value{SingleValue(111.22)}, "%111.22", nil},
+		{".##", []Value{SingleValue(.999)}, "%1.00", nil},
+		{"#.##", []Value{SingleValue(.125), DoubleValue(2.675)}, "0.132.68", nil},
+		{"###", []Value{IntegerValue(-12), IntegerValue(0), DoubleValue(-0.4)}, "-12  0  0", nil},
+		{"_!##_!", []Value{IntegerValue(12)}, "!12!", nil},
+		{"#### total", []Value{IntegerValue(12)}, "  12 total", nil},
+		{"#, #.", []Value{IntegerValue(1), IntegerValue(2)}, " 1 2.", nil},
+		{"x=#", []Value{}, "x=", nil},
+		{"abc", []Value{IntegerValue(1)}, "", ErrIllegalFunctionCall},
+		{"!", []Value{IntegerValue(1)}, "", ErrTypeMismatch},
+		{"#", []Value{StringValue("a")}, "", ErrTypeMismatch},
+		{"#########################", []Value{IntegerValue(1)}, "", ErrIllegalFunctionCall},
+	}
+
+	for _, c := range cases {
+		s, err := FormatUsing(c.format, c.vals)
+		if err != c.err {
+			t.Errorf("FormatUsing(%q, %v) got error %v want %v", c.format, c.vals, err, c.err)
+		} else if s != c.s {
+			t.Errorf("FormatUsing(%q, %v) got %q want %q", c.format, c.vals, s, c.s)
+		}
+	}
+}
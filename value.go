@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrIllegalFunctionCall = errors.New("Illegal function call")
+	ErrOverflow            = errors.New("Overflow")
+	ErrTypeMismatch        = errors.New("Type mismatch")
+)
+
+type Type byte
+
+const (
+	NoType Type = iota
+	IntegerType
+	SingleType
+	DoubleType
+	StringType
+	BooleanType
+	NumTypes
+)
+
+var typeNames = [NumTypes]string{
+	NoType:      "no",
+	IntegerType: "integer",
+	SingleType:  "single precision",
+	DoubleType:  "double precision",
+	StringType:  "string",
+	BooleanType: "boolean",
+}
+
+func (t Type) String() string {
+	return typeNames[t]
+}
+
+func (t Type) Numeric() bool {
+	return t == IntegerType || t == SingleType || t == DoubleType
+}
+
+// Integers are 16 bits.
+const (
+	MinInteger = -32768
+	MaxInteger = 32767
+)
+
+// VarType returns the type of a variable based on the suffix of its name, or NoType if it
+// does not have one.
+func VarType(name string) Type {
+	switch name[len(name)-1] {
+	case '$':
+		return StringType
+	case '%':
+		return IntegerType
+	case '!':
+		return SingleType
+	case '#':
+		return DoubleType
+	}
+	return NoType
+}
+
+// typeSuffixes are the suffixes of the names of variables of each type.
+var typeSuffixes = [NumTypes]string{
+	IntegerType: "%",
+	SingleType:  "!",
+	DoubleType:  "#",
+	StringType:  "$",
+}
+
+// Value is a BASIC value. Integer is used for integer and boolean values, Float for single
+// and double precision values, and String for string values. A Value with NoType is a
+// variable which has not been assigned.
+type Value struct {
+	Type    Type
+	Integer int
+	Float   float64
+	String  string
+}
+
+func IntegerValue(n int) Value {
+	return Value{Type: IntegerType, Integer: n}
+}
+
+func SingleValue(f float64) Value {
+	return Value{Type: SingleType, Float: float64(float32(f))}
+}
+
+func DoubleValue(f float64) Value {
+	return Value{Type: DoubleType, Float: f}
+}
+
+func StringValue(s string) Value {
+	return Value{Type: StringType, String: s}
+}
+
+func BooleanValue(t bool) Value {
+	if t {
+		return Value{Type: BooleanType, Integer: 1}
+	}
+	return Value{Type: BooleanType}
+}
+
+// NumberValue converts f to a value of numeric type t.
+func NumberValue(f float64, t Type) (Value, error) {
+	switch t {
+	case IntegerType:
+		f = math.Round(f)
+		if f < MinInteger || f > MaxInteger {
+			return Value{}, ErrOverflow
+		}
+		return IntegerValue(int(f)), nil
+	case SingleType:
+		v := SingleValue(f)
+		if math.IsInf(v.Float, 0) {
+			return Value{}, ErrOverflow
+		}
+		return v, nil
+	default:
+		return DoubleValue(f), nil
+	}
+}
+
+func (v Value) Format() string {
+	switch v.Type {
+	case IntegerType:
+		return strconv.Itoa(v.Integer)
+	case SingleType:
+		return strconv.FormatFloat(v.Float, 'f', -1, 32)
+	case DoubleType:
+		return strconv.FormatFloat(v.Float, 'f', -1, 64)
+	case StringType:
+		return v.String
+	case BooleanType:
+		if v.Integer != 0 {
+			return "TRUE"
+		}
+		return "FALSE"
+	default:
+		panic("unexpected value type")
+	}
+}
+
+// Print formats a value the way PRINT outputs it. Numbers have a leading space or minus sign
+// and a trailing space. Single precision numbers are rounded to 7 significant digits and
+// double precision numbers to 16; they are printed in fixed point if that takes no more digits
+// than that, and in scientific notation otherwise.
+func (v Value) Print() string {
+	switch v.Type {
+	case IntegerType:
+		if v.Integer < 0 {
+			return strconv.Itoa(v.Integer) + " "
+		}
+		return " " + strconv.Itoa(v.Integer) + " "
+	case SingleType:
+		return formatFloat(v.Float, 7, 'E') + " "
+	case DoubleType:
+		return formatFloat(v.Float, 16, 'D') + " "
+	default:
+		return v.Format()
+	}
+}
+
+// Write formats a value the way WRITE outputs it: strings are quoted and numbers do not
+// have leading or trailing spaces.
+func (v Value) Write() string {
+	if v.Type == StringType {
+		return `"` + v.String + `"`
+	}
+	return strings.TrimSpace(v.Print())
+}
+
+// printDigits returns the significant digits and exponent of f rounded to n significant
+// digits, with halves rounded away from zero the way the BASIC-80 number output routine
+// does; the value is 0.ddd times 10 to the exponent. The digits are empty if f is zero.
+func printDigits(f float64, n int) (string, int) {
+	s := strconv.FormatFloat(math.Abs(f), 'e', 40, 64)
+	i := strings.IndexByte(s, 'e')
+	e, _ := strconv.Atoi(s[i+1:])
+	d := strings.TrimRight(s[:1]+s[2:i], "0")
+	if d == "" {
+		return "", 0
+	}
+	return roundDigits(d, e+1, n)
+}
+
+func formatFloat(f float64, digits int, exp byte) string {
+	sign := " "
+	if f < 0 {
+		sign = "-"
+	}
+	d, e := printDigits(f, digits)
+	if d == "" {
+		return " 0"
+	}
+
+	e -= 1
+	if e >= 0 && e < digits {
+		if len(d) <= e+1 {
+			return sign + d + strings.Repeat("0", e+1-len(d))
+		}
+		return sign + d[:e+1] + "." + d[e+1:]
+	} else if e < 0 && -e-1+len(d) <= digits {
+		return sign + "." + strings.Repeat("0", -e-1) + d
+	}
+
+	m := d[:1]
+	if len(d) > 1 {
+		m += "." + d[1:]
+	}
+	es := "+"
+	if e < 0 {
+		es = "-"
+		e = -e
+	}
+	return fmt.Sprintf("%s%s%c%s%02d", sign, m, exp, es, e)
+}
@@ -0,0 +1,1024 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/btree"
+)
+
+type Opcode int32
+
+const (
+	OpEnd Opcode = iota
+	OpConst
+	OpLoad
+	OpStore
+
+	OpIntegerToSingle
+	OpIntegerToDouble
+	OpSingleToInteger
+	OpSingleToDouble
+	OpDoubleToInteger
+	OpDoubleToSingle
+
+	OpNegateInteger
+	OpNegateSingle
+	OpNegateDouble
+
+	OpAddInteger
+	OpAddSingle
+	OpAddDouble
+	OpAddString
+	OpSubtractInteger
+	OpSubtractSingle
+	OpSubtractDouble
+	OpMultiplyInteger
+	OpMultiplySingle
+	OpMultiplyDouble
+	OpDivideInteger
+	OpDivideSingle
+	OpDivideDouble
+
+	OpEqualInteger
+	OpEqualFloat
+	OpEqualString
+	OpNotEqualInteger
+	OpNotEqualFloat
+	OpNotEqualString
+	OpLessInteger
+	OpLessFloat
+	OpLessString
+	OpLessEqualInteger
+	OpLessEqualFloat
+	OpLessEqualString
+	OpGreaterInteger
+	OpGreaterFloat
+	OpGreaterString
+	OpGreaterEqualInteger
+	OpGreaterEqualFloat
+	OpGreaterEqualString
+
+	OpPrint
+	OpPrintComma
+	OpPrintNewline
+	OpPrintTab
+	OpPrintSpc
+	OpPrintUsing
+	OpWidth
+	OpWrite
+	OpWriteComma
+
+	OpOpen
+	OpClose
+	OpCloseAll
+	OpOutputFile
+	OpInputFile
+	OpRandomFile
+	OpScreen
+	OpKeyboard
+	OpReadItem
+	OpReadLine
+	OpEOF
+	OpLOC
+	OpLOF
+	OpField
+	OpGet
+	OpPut
+	OpLSet
+	OpRSet
+	OpMKI
+	OpMKS
+	OpMKD
+	OpCVI
+	OpCVS
+	OpCVD
+	OpChr
+	OpKill
+	OpName
+	OpFiles
+	OpAssert
+	OpExit
+
+	OpJump
+	OpJumpFalse
+	OpGoSub
+	OpReturn
+	NumOpcodes
+)
+
+// opcodeArgs is the number of operands which follow each opcode in Image.Code.
+var opcodeArgs = [NumOpcodes]int{
+	OpConst:      1,
+	OpLoad:       1,
+	OpStore:      1,
+	OpPrintUsing: 1,
+	OpReadItem:   1,
+	OpField:      1,
+	OpGet:        1,
+	OpPut:        1,
+	OpLSet:       1,
+	OpRSet:       1,
+	OpExit:       1,
+	OpJump:       1,
+	OpJumpFalse:  1,
+	OpGoSub:      1,
+}
+
+// opcodeStack is the number of values each opcode pops off and pushes onto the stack; it
+// is filled in for the unary and binary operators and the functions by init. OpPrintUsing
+// also pops the number of values given by its operand, and OpGet and OpPut pop a record
+// number if their operand is one.
+var opcodeStack = [NumOpcodes][2]int{
+	OpConst:      {0, 1},
+	OpLoad:       {0, 1},
+	OpStore:      {1, 0},
+	OpPrint:      {1, 0},
+	OpPrintTab:   {1, 0},
+	OpPrintSpc:   {1, 0},
+	OpPrintUsing: {1, 0},
+	OpWidth:      {1, 0},
+	OpWrite:      {1, 0},
+	OpOpen:       {4, 0},
+	OpClose:      {1, 0},
+	OpOutputFile: {1, 0},
+	OpInputFile:  {1, 0},
+	OpRandomFile: {1, 0},
+	OpField:      {1, 0},
+	OpLSet:       {1, 0},
+	OpRSet:       {1, 0},
+	OpKeyboard:   {1, 0},
+	OpReadItem:   {0, 1},
+	OpReadLine:   {0, 1},
+	OpKill:       {1, 0},
+	OpName:       {2, 0},
+	OpFiles:      {1, 0},
+	OpAssert:     {2, 0},
+	OpExit:       {1, 0},
+	OpJumpFalse:  {1, 0},
+}
+
+var convertOpcodes = [NumTypes][NumTypes]Opcode{
+	IntegerType: {SingleType: OpIntegerToSingle, DoubleType: OpIntegerToDouble},
+	SingleType:  {IntegerType: OpSingleToInteger, DoubleType: OpSingleToDouble},
+	DoubleType:  {IntegerType: OpDoubleToInteger, SingleType: OpDoubleToSingle},
+}
+
+func init() {
+	for op := OpIntegerToSingle; op <= OpNegateDouble; op += 1 {
+		opcodeStack[op] = [2]int{1, 1}
+	}
+	for op := OpAddInteger; op <= OpGreaterEqualString; op += 1 {
+		opcodeStack[op] = [2]int{2, 1}
+	}
+	for _, fn := range Functions {
+		opcodeStack[fn.Opcode] = [2]int{len(fn.Args), 1}
+	}
+}
+
+// LineInfo records the offset in Image.Code of the first instruction of a line.
+type LineInfo struct {
+	Number int
+	PC     int
+}
+
+// Image is a program compiled to bytecode for a stack machine. Operands to instructions
+// are indexes into Consts or Names, or offsets into Code for jumps.
+type Image struct {
+	Code   []int32
+	Consts []Value
+	Names  []string
+	Lines  []LineInfo
+}
+
+// LineNumber returns the number of the line containing the instruction at pc.
+func (img *Image) LineNumber(pc int) (int, bool) {
+	i := sort.Search(len(img.Lines),
+		func(i int) bool {
+			return img.Lines[i].PC > pc
+		})
+	if i == 0 {
+		return 0, false
+	}
+	return img.Lines[i-1].Number, true
+}
+
+type fixup struct {
+	pc     int
+	number int
+}
+
+type Compiler struct {
+	b      *Basic
+	img    *Image
+	consts map[Value]int32
+	fixups []fixup
+	number int
+	inLine bool
+}
+
+func NewCompiler(b *Basic) *Compiler {
+	return &Compiler{
+		b:      b,
+		img:    &Image{},
+		consts: map[Value]int32{},
+	}
+}
+
+func (c *Compiler) Error(msg string) {
+	if c.inLine {
+		fmt.Fprintf(c.b.ErrW, "basic: error: %d: %s\n", c.number, msg)
+	} else {
+		fmt.Fprintf(c.b.ErrW, "basic: error: %s\n", msg)
+	}
+}
+
+func (c *Compiler) PC() int {
+	return len(c.img.Code)
+}
+
+func (c *Compiler) Emit(op Opcode, args ...int32) {
+	c.img.Code = append(c.img.Code, int32(op))
+	c.img.Code = append(c.img.Code, args...)
+}
+
+func (c *Compiler) EmitConst(val Value) {
+	n, ok := c.consts[val]
+	if !ok {
+		n = int32(len(c.img.Consts))
+		c.img.Consts = append(c.img.Consts, val)
+		c.consts[val] = n
+	}
+	c.Emit(OpConst, n)
+}
+
+// Convert converts a value of type from, computed by the code ending at offset at, to type
+// to. The code following at must not contain any jumps.
+func (c *Compiler) Convert(at int, from, to Type) {
+	if from == to {
+		return
+	}
+	op := convertOpcodes[from][to]
+	if op == 0 {
+		panic(fmt.Sprintf("unexpected conversion from %s to %s", from, to))
+	}
+	c.img.Code = append(c.img.Code, 0)
+	copy(c.img.Code[at+1:], c.img.Code[at:])
+	c.img.Code[at] = int32(op)
+}
+
+// CompileInteger compiles a numeric expression and converts it to an integer.
+func (c *Compiler) CompileInteger(e Expr) bool {
+	t, ok := e.Compile(c)
+	if !ok {
+		return false
+	}
+	if !t.Numeric() {
+		c.Error(ErrTypeMismatch.Error())
+		return false
+	}
+	c.Convert(c.PC(), t, IntegerType)
+	return true
+}
+
+// Var returns the slot and type of the variable called name. A name without a type suffix
+// has the type given to its first letter by DEFINT, DEFSNG, DEFDBL, or DEFSTR, so A is the
+// same variable as A! unless the type of A has been changed.
+func (c *Compiler) Var(name string) (int, Type) {
+	name, t := TypedName(&c.b.DefTypes, name)
+	return c.b.Slot(name), t
+}
+
+// TypedName returns the name of a variable with its type suffix, which is the type given to
+// its first letter in defTypes if it doesn't have one, and its type.
+func TypedName(defTypes *[26]Type, name string) (string, Type) {
+	t := VarType(name)
+	if t == NoType {
+		t = defTypes[name[0]-'A']
+		name += typeSuffixes[t]
+	}
+	return name, t
+}
+
+// StringVar returns the slot of a variable which must be a string variable.
+func (c *Compiler) StringVar(v VarExpr) (int, bool) {
+	slot, t := c.Var(v.Name)
+	if t != StringType {
+		c.Error(ErrTypeMismatch.Error())
+		return 0, false
+	}
+	return slot, true
+}
+
+// CompileString compiles an expression which must be a string value.
+func (c *Compiler) CompileString(e Expr) bool {
+	t, ok := e.Compile(c)
+	if !ok {
+		return false
+	}
+	if t != StringType {
+		c.Error(ErrTypeMismatch.Error())
+		return false
+	}
+	return true
+}
+
+// CompileTest compiles an expression which must be a boolean value, for example the test of
+// an IF statement.
+func (c *Compiler) CompileTest(e Expr) bool {
+	t, ok := e.Compile(c)
+	if !ok {
+		return false
+	}
+	if t != BooleanType {
+		c.Error(ErrTypeMismatch.Error())
+		return false
+	}
+	return true
+}
+
+// EmitLine emits a jump to line number n; the target is fixed up once all of the lines have
+// been compiled.
+func (c *Compiler) EmitLine(op Opcode, n int) {
+	c.Emit(op, 0)
+	c.fixups = append(c.fixups, fixup{c.PC() - 1, n})
+}
+
+// EmitJump emits a jump to a target which is not known yet, and returns the offset of the
+// operand to pass to Patch.
+func (c *Compiler) EmitJump(op Opcode) int {
+	c.Emit(op, 0)
+	return c.PC() - 1
+}
+
+func (c *Compiler) Patch(at int) {
+	c.img.Code[at] = int32(c.PC())
+}
+
+func (c *Compiler) Line(n int, stmt Stmt) bool {
+	c.img.Lines = append(c.img.Lines, LineInfo{n, c.PC()})
+	c.number = n
+	c.inLine = true
+	ok := stmt.Compile(c)
+	c.inLine = false
+	return ok
+}
+
+// Image finishes compiling: jumps to line numbers are resolved to the first line numbered
+// greater than or equal to the target, so a jump to a missing line continues at the next line.
+func (c *Compiler) Image() *Image {
+	c.Emit(OpEnd)
+	for _, f := range c.fixups {
+		i := sort.Search(len(c.img.Lines),
+			func(i int) bool {
+				return c.img.Lines[i].Number >= f.number
+			})
+		pc := c.PC() - 1
+		if i < len(c.img.Lines) {
+			pc = c.img.Lines[i].PC
+		}
+		c.img.Code[f.pc] = int32(pc)
+	}
+
+	c.img.Names = make([]string, len(c.b.Vars))
+	for name, slot := range c.b.Names {
+		c.img.Names[slot] = name
+	}
+	return c.img
+}
+
+func (b *Basic) Compile() (*Image, bool) {
+	b.ResetDefTypes()
+	c := NewCompiler(b)
+	ok := true
+	b.Code.Ascend(
+		func(item btree.Item) bool {
+			line := item.(Line)
+			ok = c.Line(line.Number, line.Stmt)
+			return ok
+		})
+	if !ok {
+		return nil, false
+	}
+	return c.Image(), true
+}
+
+// Check compiles the program to find type errors, such as assigning a number to a string
+// variable, before it is run.
+func (b *Basic) Check() bool {
+	defTypes := b.DefTypes
+	_, ok := b.Compile()
+	b.DefTypes = defTypes
+	return ok
+}
+
+// CheckLine compiles the line numbered n, which is about to be added to the program, to find
+// type errors. The types of variables without a suffix are those given by the DEFINT, DEFSNG,
+// DEFDBL, and DEFSTR statements on the lines before it.
+func (b *Basic) CheckLine(n int, stmt Stmt) bool {
+	defTypes := b.DefTypes
+	defer func() {
+		b.DefTypes = defTypes
+	}()
+
+	b.ResetDefTypes()
+	c := NewCompiler(b)
+	b.Code.AscendLessThan(Line{n, nil},
+		func(item btree.Item) bool {
+			stmt := item.(Line).Stmt
+			if cs, ok := stmt.(CommentStmt); ok {
+				stmt = cs.Stmt
+			}
+			if ds, ok := stmt.(DefStmt); ok {
+				ds.Compile(c)
+			}
+			return true
+		})
+	return c.Line(n, stmt)
+}
+
+// SetImage makes the variables used by img, which may have been read from a file, the
+// variables of b.
+func (b *Basic) SetImage(img *Image) {
+	b.Vars = make([]Value, len(img.Names))
+	b.Names = map[string]int{}
+	for slot, name := range img.Names {
+		b.Names[name] = slot
+	}
+}
+
+// readKeyboard reads a line from b.In for INPUT or LINE INPUT; the line ends the line on the
+// screen.
+func (b *Basic) readKeyboard() (string, error) {
+	if b.In == nil {
+		return "", ErrInputPastEnd
+	}
+	line, err := b.In.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			err = ErrInputPastEnd
+		}
+		return "", err
+	}
+	if b.Echo {
+		io.WriteString(b.Screen.W, strings.TrimRight(line, "\r\n")+"\n")
+	}
+	b.Screen.Column = 0
+	return line, nil
+}
+
+func (b *Basic) runtimeError(img *Image, pc int, msg string) {
+	if n, ok := img.LineNumber(pc); ok {
+		fmt.Fprintf(b.ErrW, "basic: error: %d: %s\n", n, msg)
+	} else {
+		fmt.Fprintf(b.ErrW, "basic: error: %s\n", msg)
+	}
+}
+
+// roundSingle rounds the result of a single precision operation to a float32, or to an MBF
+// single if b.MBF is set.
+func (b *Basic) roundSingle(f float64) float64 {
+	if b.MBF {
+		return MBFRoundSingle(f)
+	}
+	return float64(float32(f))
+}
+
+func boolInt(t bool) int {
+	if t {
+		return 1
+	}
+	return 0
+}
+
+// Interrupt stops the program which is running, as Control-C does, before its next
+// instruction; it may be called from another goroutine.
+func (b *Basic) Interrupt() {
+	atomic.StoreInt32(&b.interrupted, 1)
+}
+
+// Execute runs img from the start, and returns false if it stops with an error or is
+// interrupted.
+func (b *Basic) Execute(img *Image) bool {
+	atomic.StoreInt32(&b.interrupted, 0)
+	return b.execute(img, 0, nil)
+}
+
+// execute runs img from pc with the GOSUBs and loops of stk.
+func (b *Basic) execute(img *Image, pc int, stk []Ctx) bool {
+	vals := make([]Value, 0, 16)
+
+	// PRINT # and WRITE # send their output to a file, INPUT # reads from one, and FIELD,
+	// GET, and PUT use a random file, by selecting the file first.
+	out := &b.Screen
+	var in, rf *File
+	var offset int
+
+	var prof *profiler
+	if b.Profile != nil && len(img.Lines) > 0 {
+		prof = newProfiler(b.Profile, img)
+		defer prof.stop()
+	}
+
+	code := img.Code
+	steps := 0
+	for {
+		if b.stepLimit > 0 {
+			steps += 1
+			if steps > b.stepLimit {
+				atomic.StoreInt32(&b.interrupted, 1)
+			}
+		}
+		if atomic.LoadInt32(&b.interrupted) != 0 {
+			atomic.StoreInt32(&b.interrupted, 0)
+			if n, ok := img.LineNumber(pc); ok {
+				fmt.Fprintf(b.ErrW, "Break in %d\n", n)
+			} else {
+				fmt.Fprintln(b.ErrW, "Break")
+			}
+			b.status = ExitBreak
+			return false
+		}
+		if prof != nil {
+			prof.step(pc)
+		}
+		op := Opcode(code[pc])
+		pc += 1
+
+		// Binary operators leave their result in v1 and pop v2.
+		var v1, v2 *Value
+		if op >= OpAddInteger && op <= OpGreaterEqualString {
+			v1 = &vals[len(vals)-2]
+			v2 = &vals[len(vals)-1]
+			vals = vals[:len(vals)-1]
+		}
+
+		switch op {
+		case OpEnd:
+			return true
+
+		case OpConst:
+			vals = append(vals, img.Consts[code[pc]])
+			pc += 1
+
+		case OpLoad:
+			val := b.Vars[code[pc]]
+			if val.Type == NoType {
+				b.runtimeError(img, pc,
+					fmt.Sprintf("variable not found: %s", img.Names[code[pc]]))
+				return false
+			}
+			vals = append(vals, val)
+			pc += 1
+
+		case OpStore:
+			b.Vars[code[pc]] = vals[len(vals)-1]
+			vals = vals[:len(vals)-1]
+			if len(b.Fields) > 0 {
+				delete(b.Fields, int(code[pc]))
+			}
+			pc += 1
+
+		case OpIntegerToSingle:
+			vals[len(vals)-1] = SingleValue(float64(vals[len(vals)-1].Integer))
+		case OpIntegerToDouble:
+			vals[len(vals)-1] = DoubleValue(float64(vals[len(vals)-1].Integer))
+		case OpSingleToInteger, OpDoubleToInteger:
+			f := math.Round(vals[len(vals)-1].Float)
+			if f < MinInteger || f > MaxInteger {
+				b.runtimeError(img, pc-1, "Overflow")
+				return false
+			}
+			vals[len(vals)-1] = IntegerValue(int(f))
+		case OpSingleToDouble:
+			vals[len(vals)-1].Type = DoubleType
+		case OpDoubleToSingle:
+			vals[len(vals)-1].Type = SingleType
+			vals[len(vals)-1].Float = b.roundSingle(vals[len(vals)-1].Float)
+			if math.IsInf(vals[len(vals)-1].Float, 0) {
+				b.runtimeError(img, pc-1, "Overflow")
+				return false
+			}
+
+		case OpNegateInteger:
+			if vals[len(vals)-1].Integer == MinInteger {
+				b.runtimeError(img, pc-1, "Overflow")
+				return false
+			}
+			vals[len(vals)-1].Integer = -vals[len(vals)-1].Integer
+		case OpNegateSingle, OpNegateDouble:
+			vals[len(vals)-1].Float = -vals[len(vals)-1].Float
+
+		case OpAddInteger:
+			v1.Integer += v2.Integer
+			if v1.Integer < MinInteger || v1.Integer > MaxInteger {
+				b.runtimeError(img, pc-1, "Overflow")
+				return false
+			}
+		case OpAddSingle:
+			v1.Float = b.roundSingle(v1.Float + v2.Float)
+		case OpAddDouble:
+			v1.Float += v2.Float
+		case OpAddString:
+			v1.String += v2.String
+		case OpSubtractInteger:
+			v1.Integer -= v2.Integer
+			if v1.Integer < MinInteger || v1.Integer > MaxInteger {
+				b.runtimeError(img, pc-1, "Overflow")
+				return false
+			}
+		case OpSubtractSingle:
+			v1.Float = b.roundSingle(v1.Float - v2.Float)
+		case OpSubtractDouble:
+			v1.Float -= v2.Float
+		case OpMultiplyInteger:
+			v1.Integer *= v2.Integer
+			if v1.Integer < MinInteger || v1.Integer > MaxInteger {
+				b.runtimeError(img, pc-1, "Overflow")
+				return false
+			}
+		case OpMultiplySingle:
+			v1.Float = b.roundSingle(v1.Float * v2.Float)
+		case OpMultiplyDouble:
+			v1.Float *= v2.Float
+		case OpDivideInteger:
+			if v2.Integer == 0 {
+				b.runtimeError(img, pc-1, "Division by zero")
+				return false
+			}
+			v1.Integer /= v2.Integer
+			if v1.Integer > MaxInteger {
+				b.runtimeError(img, pc-1, "Overflow")
+				return false
+			}
+		case OpDivideSingle:
+			if v2.Float == 0 {
+				b.runtimeError(img, pc-1, "Division by zero")
+				return false
+			}
+			v1.Float = b.roundSingle(v1.Float / v2.Float)
+		case OpDivideDouble:
+			if v2.Float == 0 {
+				b.runtimeError(img, pc-1, "Division by zero")
+				return false
+			}
+			v1.Float /= v2.Float
+
+		case OpEqualInteger:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Integer == v2.Integer)}
+		case OpEqualFloat:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Float == v2.Float)}
+		case OpEqualString:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.String == v2.String)}
+		case OpNotEqualInteger:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Integer != v2.Integer)}
+		case OpNotEqualFloat:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Float != v2.Float)}
+		case OpNotEqualString:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.String != v2.String)}
+		case OpLessInteger:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Integer < v2.Integer)}
+		case OpLessFloat:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Float < v2.Float)}
+		case OpLessString:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.String < v2.String)}
+		case OpLessEqualInteger:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Integer <= v2.Integer)}
+		case OpLessEqualFloat:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Float <= v2.Float)}
+		case OpLessEqualString:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.String <= v2.String)}
+		case OpGreaterInteger:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Integer > v2.Integer)}
+		case OpGreaterFloat:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Float > v2.Float)}
+		case OpGreaterString:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.String > v2.String)}
+		case OpGreaterEqualInteger:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Integer >= v2.Integer)}
+		case OpGreaterEqualFloat:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.Float >= v2.Float)}
+		case OpGreaterEqualString:
+			*v1 = Value{Type: BooleanType, Integer: boolInt(v1.String >= v2.String)}
+
+		case OpPrint:
+			out.printValue(vals[len(vals)-1])
+			vals = vals[:len(vals)-1]
+
+		case OpPrintComma:
+			out.printComma()
+
+		case OpPrintNewline:
+			out.printNewline()
+
+		case OpPrintTab, OpPrintSpc, OpWidth:
+			n := vals[len(vals)-1].Integer
+			vals = vals[:len(vals)-1]
+			if op == OpPrintTab && n >= 1 && n <= 255 {
+				out.printTab(n)
+			} else if op == OpPrintSpc && n >= 0 && n <= 255 {
+				out.printSpaces(n)
+			} else if op == OpWidth && n >= 15 && n <= 255 {
+				b.Screen.Width = n
+			} else {
+				b.runtimeError(img, pc-1, "Illegal function call")
+				return false
+			}
+
+		case OpPrintUsing:
+			n := int(code[pc])
+			s, err := FormatUsing(vals[len(vals)-n-1].String, vals[len(vals)-n:])
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+			vals = vals[:len(vals)-n-1]
+			out.printString(s)
+			pc += 1
+
+		case OpWrite:
+			out.printString(vals[len(vals)-1].Write())
+			vals = vals[:len(vals)-1]
+
+		case OpWriteComma:
+			out.printString(",")
+
+		case OpOpen:
+			v := vals[len(vals)-4:]
+			vals = vals[:len(vals)-4]
+			if err := b.Open(v[0].String, v[1].Integer, v[2].String, v[3].Integer); err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+
+		case OpClose:
+			n := vals[len(vals)-1].Integer
+			vals = vals[:len(vals)-1]
+			if err := b.Close(n); err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+
+		case OpCloseAll:
+			b.CloseFiles()
+
+		case OpKill:
+			err := b.Kill(vals[len(vals)-1].String)
+			vals = vals[:len(vals)-1]
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+
+		case OpName:
+			err := b.Rename(vals[len(vals)-2].String, vals[len(vals)-1].String)
+			vals = vals[:len(vals)-2]
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+
+		case OpFiles:
+			names, err := b.ListFiles(vals[len(vals)-1].String)
+			vals = vals[:len(vals)-1]
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+			if b.Screen.Column > 0 {
+				b.Screen.printNewline()
+			}
+			for i, name := range names {
+				if i > 0 {
+					b.Screen.printComma()
+				}
+				b.Screen.printString(name)
+			}
+			b.Screen.printNewline()
+
+		case OpExit:
+			n := vals[len(vals)-1].Integer
+			vals = vals[:len(vals)-1]
+			if n < 0 || n > 255 {
+				b.runtimeError(img, pc-1, ErrIllegalFunctionCall.Error())
+				return false
+			}
+			b.status = int(n)
+			b.system = code[pc] != 0
+			return true
+
+		case OpAssert:
+			t := vals[len(vals)-2].Integer != 0
+			msg := vals[len(vals)-1].String
+			vals = vals[:len(vals)-2]
+			if !t {
+				if msg != "" {
+					msg = "ASSERT failed: " + msg
+				} else {
+					msg = "ASSERT failed"
+				}
+				b.runtimeError(img, pc-1, msg)
+				return false
+			}
+
+		case OpOutputFile, OpInputFile, OpRandomFile:
+			modes := "OA"
+			if op == OpInputFile {
+				modes = "I"
+			} else if op == OpRandomFile {
+				modes = "R"
+			}
+			f, err := b.File(vals[len(vals)-1].Integer, modes)
+			vals = vals[:len(vals)-1]
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+			if op == OpOutputFile {
+				out = &f.Printer
+			} else if op == OpInputFile {
+				in = f
+			} else {
+				rf = f
+				offset = 0
+			}
+
+		case OpScreen:
+			out = &b.Screen
+
+		case OpKeyboard:
+			b.Screen.printString(vals[len(vals)-1].String)
+			vals = vals[:len(vals)-1]
+			line, err := b.readKeyboard()
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+			in = &File{Mode: 'I', r: bufio.NewReader(strings.NewReader(line))}
+
+		case OpReadItem, OpReadLine:
+			if in == nil {
+				b.runtimeError(img, pc-1, ErrBadFileNumber.Error())
+				return false
+			}
+			var v Value
+			var err error
+			if op == OpReadItem {
+				v, err = in.ReadItem(Type(code[pc]))
+				pc += 1
+			} else {
+				var s string
+				s, err = in.ReadLine()
+				v = StringValue(s)
+			}
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+			vals = append(vals, v)
+
+		case OpEOF, OpLOC, OpLOF:
+			f, err := b.File(vals[len(vals)-1].Integer, "")
+			if err == nil {
+				switch op {
+				case OpEOF:
+					var eof bool
+					eof, err = f.EOF()
+					vals[len(vals)-1] = BooleanValue(eof)
+				case OpLOC:
+					vals[len(vals)-1] = SingleValue(float64(f.Loc()))
+				case OpLOF:
+					var n int64
+					n, err = f.Len()
+					vals[len(vals)-1] = SingleValue(float64(n))
+				}
+			}
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+
+		case OpField, OpGet, OpPut:
+			if rf == nil {
+				b.runtimeError(img, pc-1, ErrBadFileNumber.Error())
+				return false
+			}
+			var err error
+			if op == OpField {
+				n := vals[len(vals)-1].Integer
+				vals = vals[:len(vals)-1]
+				err = b.Field(rf, offset, n, int(code[pc]))
+				offset += n
+			} else {
+				rec := rf.rec + 1
+				if code[pc] == 1 {
+					rec = vals[len(vals)-1].Integer
+					vals = vals[:len(vals)-1]
+				}
+				if op == OpGet {
+					err = rf.Get(rec)
+					b.syncFields(rf)
+				} else {
+					err = rf.Put(rec)
+				}
+			}
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+			pc += 1
+
+		case OpLSet, OpRSet:
+			b.SetField(int(code[pc]), vals[len(vals)-1].String, op == OpRSet)
+			vals = vals[:len(vals)-1]
+			pc += 1
+
+		case OpMKI:
+			vals[len(vals)-1] = StringValue(mki(vals[len(vals)-1].Integer))
+		case OpMKS, OpMKD:
+			var s string
+			var err error
+			if op == OpMKS {
+				s, err = MBFSingle(vals[len(vals)-1].Float)
+			} else {
+				s, err = MBFDouble(vals[len(vals)-1].Float)
+			}
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+			vals[len(vals)-1] = StringValue(s)
+		case OpCVI, OpCVS, OpCVD:
+			var v Value
+			var err error
+			switch op {
+			case OpCVI:
+				v, err = cvi(vals[len(vals)-1].String)
+			case OpCVS:
+				v, err = cvs(vals[len(vals)-1].String)
+			case OpCVD:
+				v, err = cvd(vals[len(vals)-1].String)
+			}
+			if err != nil {
+				b.runtimeError(img, pc-1, err.Error())
+				return false
+			}
+			vals[len(vals)-1] = v
+		case OpChr:
+			n := vals[len(vals)-1].Integer
+			if n < 0 || n > 255 {
+				b.runtimeError(img, pc-1, ErrIllegalFunctionCall.Error())
+				return false
+			}
+			vals[len(vals)-1] = StringValue(string([]byte{byte(n)}))
+
+		case OpJump:
+			pc = int(code[pc])
+
+		case OpJumpFalse:
+			t := vals[len(vals)-1].Integer != 0
+			vals = vals[:len(vals)-1]
+			if t {
+				pc += 1
+			} else {
+				pc = int(code[pc])
+			}
+
+		case OpGoSub:
+			stk = append(stk, Ctx{GoSubCtx, pc + 1})
+			pc = int(code[pc])
+
+		case OpReturn:
+			for {
+				if len(stk) == 0 {
+					b.runtimeError(img, pc-1, "RETURN without a GOSUB")
+					return false
+				}
+				ctx := stk[len(stk)-1]
+				stk = stk[:len(stk)-1]
+				if ctx.Type == GoSubCtx {
+					pc = ctx.Number
+					break
+				}
+			}
+
+		default:
+			panic(fmt.Sprintf("unexpected opcode: %d", op))
+		}
+
+		if v1 != nil && (v1.Type == SingleType || v1.Type == DoubleType) {
+			if b.MBF && v1.Type == DoubleType {
+				v1.Float = MBFRangeDouble(v1.Float)
+			}
+			if math.IsInf(v1.Float, 0) {
+				b.runtimeError(img, pc-1, "Overflow")
+				return false
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/btree"
+)
+
+// XrefLine is a line number and the lines which jump to it with GOTO, GOSUB, IF GOTO, or
+// THEN and ELSE.
+type XrefLine struct {
+	Line int   `json:"line"`
+	From []int `json:"from"`
+}
+
+// XrefVar is a variable, with its type suffix, and the lines which assign and read it.
+type XrefVar struct {
+	Name     string `json:"name"`
+	Assigned []int  `json:"assigned"`
+	Read     []int  `json:"read"`
+}
+
+// Xref is a cross-reference of the line numbers and variables of a program.
+type Xref struct {
+	Lines     []XrefLine `json:"lines"`
+	Variables []XrefVar  `json:"variables"`
+}
+
+// walkExpr calls read for each variable in e, which may be nil.
+func walkExpr(e Expr, read func(v VarExpr)) {
+	switch e := e.(type) {
+	case VarExpr:
+		read(e)
+	case ParenExpr:
+		walkExpr(e.Expr, read)
+	case NegateExpr:
+		walkExpr(e.Expr, read)
+	case BinaryExpr:
+		walkExpr(e.Left, read)
+		walkExpr(e.Right, read)
+	case CallExpr:
+		for _, arg := range e.Args {
+			walkExpr(arg, read)
+		}
+	}
+}
+
+// walkStmt calls read for each variable which stmt reads and assign for each variable which
+// it assigns. FIELD, LSET, and RSET count as assigning their variables.
+func walkStmt(stmt Stmt, read, assign func(v VarExpr)) {
+	switch stmt := stmt.(type) {
+	case AssignStmt:
+		walkExpr(stmt.Expr, read)
+		assign(VarExpr{stmt.Var})
+	case PrintStmt:
+		walkExpr(stmt.File, read)
+		walkExpr(stmt.Using, read)
+		for _, item := range stmt.Items {
+			walkExpr(item.Expr, read)
+		}
+	case WriteStmt:
+		walkExpr(stmt.File, read)
+		for _, e := range stmt.Exprs {
+			walkExpr(e, read)
+		}
+	case OpenStmt:
+		walkExpr(stmt.Mode, read)
+		walkExpr(stmt.Number, read)
+		walkExpr(stmt.Name, read)
+		walkExpr(stmt.Length, read)
+	case CloseStmt:
+		for _, e := range stmt.Numbers {
+			walkExpr(e, read)
+		}
+	case EndStmt:
+		walkExpr(stmt.Status, read)
+	case AssertStmt:
+		walkExpr(stmt.Test, read)
+		walkExpr(stmt.Message, read)
+	case KillStmt:
+		walkExpr(stmt.Name, read)
+	case NameStmt:
+		walkExpr(stmt.Old, read)
+		walkExpr(stmt.New, read)
+	case FilesStmt:
+		walkExpr(stmt.Pattern, read)
+	case InputStmt:
+		walkExpr(stmt.File, read)
+		for _, v := range stmt.Vars {
+			assign(v)
+		}
+	case LineInputStmt:
+		walkExpr(stmt.File, read)
+		assign(stmt.Var)
+	case FieldStmt:
+		walkExpr(stmt.File, read)
+		for _, item := range stmt.Items {
+			walkExpr(item.Length, read)
+			assign(item.Var)
+		}
+	case GetPutStmt:
+		walkExpr(stmt.File, read)
+		walkExpr(stmt.Record, read)
+	case SetStmt:
+		walkExpr(stmt.Expr, read)
+		assign(stmt.Var)
+	case WidthStmt:
+		walkExpr(stmt.Expr, read)
+	case IfThenStmt:
+		walkExpr(stmt.Test, read)
+		walkStmt(stmt.Then, read, assign)
+		if stmt.Else != nil {
+			walkStmt(stmt.Else, read, assign)
+		}
+	case IfGotoStmt:
+		walkExpr(stmt.Test, read)
+	case CommentStmt:
+		if stmt.Stmt != nil {
+			walkStmt(stmt.Stmt, read, assign)
+		}
+	}
+}
+
+// addLine adds n to the sorted list of line numbers lines, unless it is already there.
+func addLine(lines []int, n int) []int {
+	i := sort.SearchInts(lines, n)
+	if i < len(lines) && lines[i] == n {
+		return lines
+	}
+	return append(lines[:i], append([]int{n}, lines[i:]...)...)
+}
+
+// Xref builds a cross-reference of the program. The type of a variable without a suffix is
+// given by the DEFINT, DEFSNG, DEFDBL, and DEFSTR statements before it, as when the program
+// is compiled.
+func (b *Basic) Xref() Xref {
+	from := map[int][]int{}
+	assigned := map[string][]int{}
+	read := map[string][]int{}
+	var defTypes [26]Type
+	for i := range defTypes {
+		defTypes[i] = SingleType
+	}
+
+	b.Code.Ascend(
+		func(item btree.Item) bool {
+			line := item.(Line)
+			stmt := line.Stmt
+			if cs, ok := stmt.(CommentStmt); ok {
+				stmt = cs.Stmt
+			}
+			if ds, ok := stmt.(DefStmt); ok {
+				ds.Apply(&defTypes)
+			}
+
+			f := stmtFlow(line.Stmt)
+			for _, ref := range append(f.Gotos, f.GoSubs...) {
+				from[ref.Number] = addLine(from[ref.Number], line.Number)
+			}
+			walkStmt(line.Stmt,
+				func(v VarExpr) {
+					name, _ := TypedName(&defTypes, v.Name)
+					read[name] = addLine(read[name], line.Number)
+				},
+				func(v VarExpr) {
+					name, _ := TypedName(&defTypes, v.Name)
+					assigned[name] = addLine(assigned[name], line.Number)
+				})
+			return true
+		})
+
+	x := Xref{
+		Lines:     []XrefLine{},
+		Variables: []XrefVar{},
+	}
+	for n, lines := range from {
+		x.Lines = append(x.Lines, XrefLine{n, lines})
+	}
+	sort.Slice(x.Lines,
+		func(i, j int) bool {
+			return x.Lines[i].Line < x.Lines[j].Line
+		})
+
+	names := map[string]bool{}
+	for name := range assigned {
+		names[name] = true
+	}
+	for name := range read {
+		names[name] = true
+	}
+	for name := range names {
+		v := XrefVar{Name: name, Assigned: assigned[name], Read: read[name]}
+		if v.Assigned == nil {
+			v.Assigned = []int{}
+		}
+		if v.Read == nil {
+			v.Read = []int{}
+		}
+		x.Variables = append(x.Variables, v)
+	}
+	sort.Slice(x.Variables,
+		func(i, j int) bool {
+			return x.Variables[i].Name < x.Variables[j].Name
+		})
+	return x
+}
+
+func formatLines(lines []int) string {
+	s := make([]string, len(lines))
+	for i, n := range lines {
+		s[i] = fmt.Sprint(n)
+	}
+	return strings.Join(s, " ")
+}
+
+// Print writes the cross-reference as two tables: the line numbers which are jumped to and
+// where from, and the variables and where they are assigned and read.
+func (x Xref) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tJUMPED TO FROM")
+	for _, l := range x.Lines {
+		fmt.Fprintf(tw, "%d\t%s\n", l.Line, formatLines(l.From))
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	var buf strings.Builder
+	tw = tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIABLE\tASSIGNED\tREAD")
+	for _, v := range x.Variables {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", v.Name, formatLines(v.Assigned), formatLines(v.Read))
+	}
+	tw.Flush()
+
+	// A variable which is never read leaves the padding of the ASSIGNED column at the end
+	// of its line.
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line != "" {
+			fmt.Fprintln(w, strings.TrimRight(line, " \n"))
+		}
+	}
+}
+
+// WriteXref writes a cross-reference of the program to w, as JSON if asJSON is set.
+func (b *Basic) WriteXref(w io.Writer, asJSON bool) {
+	x := b.Xref()
+	if !asJSON {
+		x.Print(w)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(x)
+}
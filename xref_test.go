@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestXref(t *testing.T) {
+	src := `10 A = 1
+20 GOSUB 100
+30 IF A = 1 THEN GOTO 60 ELSE GOSUB 100
+40 IF B% > A GOTO 60
+50 DEFINT A
+60 A = A + 1 ' A is A% here
+70 INPUT #1, B%, S$
+80 FIELD #1, 10 AS F$
+90 LSET F$ = S$
+100 RETURN
+`
+	fsys := NewMemFS()
+	writeFile(t, fsys, "t.bas", src)
+
+	w := &bytes.Buffer{}
+	b := NewBasic(w, w)
+	b.FS = fsys
+	if !b.Load("t.bas") {
+		t.Fatalf("Load failed: %s", w.String())
+	}
+
+	want := Xref{
+		Lines: []XrefLine{
+			{60, []int{30, 40}},
+			{100, []int{20, 30}},
+		},
+		Variables: []XrefVar{
+			{"A!", []int{10}, []int{30, 40}},
+			{"A%", []int{60}, []int{60}},
+			{"B%", []int{70}, []int{40}},
+			{"F$", []int{80, 90}, []int{}},
+			{"S$", []int{70}, []int{90}},
+		},
+	}
+	if x := b.Xref(); !reflect.DeepEqual(x, want) {
+		t.Errorf("Xref got %v want %v", x, want)
+	}
+
+	b.WriteXref(w, false)
+	text := `LINE  JUMPED TO FROM
+60    30 40
+100   20 30
+
+VARIABLE  ASSIGNED  READ
+A!        10        30 40
+A%        60        60
+B%        70        40
+F$        80 90
+S$        70        90
+`
+	if out := w.String(); out != text {
+		t.Errorf("WriteXref got:\n%swant:\n%s", out, text)
+	}
+
+	w.Reset()
+	b.WriteXref(w, true)
+	var x Xref
+	if err := json.Unmarshal(w.Bytes(), &x); err != nil {
+		t.Errorf("WriteXref(json) failed with %s: %s", err, w.String())
+	} else if !reflect.DeepEqual(x, want) {
+		t.Errorf("WriteXref(json) got %v want %v", x, want)
+	}
+}